@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+)
+
+type BatcherConfig struct {
+	// How long single calls are collected before they are sent as one batch, defaults to 2ms
+	Wait time.Duration
+	// A batch is sent right away once it holds this many keys, defaults to 100
+	MaxBatch int
+}
+
+// Batcher collects single Get and Swr calls, e.g. from GraphQL resolvers, and sends them as one
+// GetMany or SwrMany to the namespace instead of doing a store round trip per key.
+type Batcher[T any] struct {
+	ns                Namespace[T]
+	wait              time.Duration
+	maxBatch          int
+	refreshFromOrigin func([]string) ([]GetMany[T], error)
+
+	mu  sync.Mutex
+	get *batch[T]
+	swr *batch[T]
+}
+
+type batch[T any] struct {
+	ctx    context.Context
+	keys   []string
+	seen   map[string]struct{}
+	timer  *time.Timer
+	done   chan struct{}
+	values map[string]GetMany[T]
+	err    error
+}
+
+// refreshFromOrigin is only needed when Swr is used, it is called with all keys of a batch
+// that were not found or are stale.
+func NewBatcher[T any](ns Namespace[T], cfg BatcherConfig, refreshFromOrigin func([]string) ([]GetMany[T], error)) *Batcher[T] {
+	if cfg.Wait <= 0 {
+		cfg.Wait = 2 * time.Millisecond
+	}
+
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = 100
+	}
+
+	return &Batcher[T]{
+		ns:                ns,
+		wait:              cfg.Wait,
+		maxBatch:          cfg.MaxBatch,
+		refreshFromOrigin: refreshFromOrigin,
+	}
+}
+
+func (b *Batcher[T]) Get(ctx context.Context, key string) (*T, bool, error) {
+	if key == "" {
+		return nil, false, ErrEmptyKey
+	}
+
+	v, err := b.load(ctx, &b.get, key, b.ns.GetMany)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return v.Value, v.Found, nil
+}
+
+func (b *Batcher[T]) Swr(ctx context.Context, key string) (*T, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+
+	if b.refreshFromOrigin == nil {
+		return nil, fmt.Errorf("%w: no refreshFromOrigin provided", ErrNoLoader)
+	}
+
+	v, err := b.load(ctx, &b.swr, key, func(ctx context.Context, keys []string) ([]GetMany[T], error) {
+		return b.ns.SwrMany(ctx, keys, b.refreshFromOrigin)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Value, nil
+}
+
+func (b *Batcher[T]) load(ctx context.Context, pending **batch[T], key string, run func(context.Context, []string) ([]GetMany[T], error)) (GetMany[T], error) {
+	current := b.enqueue(ctx, pending, key, run)
+
+	select {
+	case <-current.done:
+		if current.err != nil {
+			return GetMany[T]{}, current.err
+		}
+
+		if v, ok := current.values[key]; ok {
+			return v, nil
+		}
+
+		return GetMany[T]{Key: key, Value: nil, Found: false}, nil
+	case <-ctx.Done():
+		return GetMany[T]{}, ctx.Err()
+	}
+}
+
+func (b *Batcher[T]) enqueue(ctx context.Context, pending **batch[T], key string, run func(context.Context, []string) ([]GetMany[T], error)) *batch[T] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := *pending
+	if current == nil {
+		current = &batch[T]{
+			// The batch is shared, so one caller giving up must not cancel it for the others.
+			ctx:  context.WithoutCancel(ctx),
+			keys: make([]string, 0),
+			seen: make(map[string]struct{}),
+			done: make(chan struct{}),
+		}
+		current.timer = time.AfterFunc(b.wait, func() {
+			b.flush(pending, current, run)
+		})
+		*pending = current
+	}
+
+	if _, ok := current.seen[key]; !ok {
+		current.seen[key] = struct{}{}
+		current.keys = append(current.keys, key)
+	}
+
+	if len(current.keys) >= b.maxBatch {
+		current.timer.Stop()
+		*pending = nil
+		go b.execute(current, run)
+	}
+
+	return current
+}
+
+func (b *Batcher[T]) flush(pending **batch[T], current *batch[T], run func(context.Context, []string) ([]GetMany[T], error)) {
+	b.mu.Lock()
+	// was already sent because it got full
+	if *pending != current {
+		b.mu.Unlock()
+		return
+	}
+	*pending = nil
+	b.mu.Unlock()
+
+	b.execute(current, run)
+}
+
+func (b *Batcher[T]) execute(current *batch[T], run func(context.Context, []string) ([]GetMany[T], error)) {
+	ctx, span := telemetry.NewSpan(current.ctx, "batcher.execute")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "keys", Value: current.keys},
+		telemetry.AttributeKV{Key: "namespace", Value: string(b.ns.ns)},
+	)
+
+	defer func() {
+		if r := recover(); r != nil {
+			current.err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+
+		telemetry.RecordError(span, current.err)
+		close(current.done)
+	}()
+
+	values, err := run(ctx, current.keys)
+	if err != nil {
+		current.err = err
+		return
+	}
+
+	current.values = make(map[string]GetMany[T], len(values))
+	for _, v := range values {
+		current.values[v.Key] = v
+	}
+}
@@ -0,0 +1,201 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+// batchStore records the keys of every GetMany and fails them with err if it is set
+type batchStore struct {
+	*memory.MemoryStore
+	err error
+
+	mu      sync.Mutex
+	batches []string
+}
+
+func newBatchStore(err error) *batchStore {
+	return &batchStore{MemoryStore: memory.New(memory.Config{}), err: err}
+}
+
+func (s *batchStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	s.mu.Lock()
+	s.batches = append(s.batches, strings.Join(sorted, ","))
+	s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return s.MemoryStore.GetMany(ctx, ns, keys, T)
+}
+
+func (s *batchStore) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches := append([]string(nil), s.batches...)
+	sort.Strings(batches)
+
+	return batches
+}
+
+func newBatcherNamespace(store cache.Store) cache.Namespace[user] {
+	return cache.NewNamespace[user]("user", context.Background(), cache.NamespaceConfig{
+		Stores: []cache.Store{store},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+	})
+}
+
+// getAll calls Get for every key at the same time and returns the results by key
+func getAll(b *cache.Batcher[user], keys ...string) (map[string]*user, []error) {
+	mu := sync.Mutex{}
+	values := make(map[string]*user)
+	errs := make([]error, 0)
+
+	wg := sync.WaitGroup{}
+	for _, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			value, _, err := b.Get(context.Background(), key)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			values[key] = value
+		}()
+	}
+	wg.Wait()
+
+	return values, errs
+}
+
+func TestBatcherMaxBatch(t *testing.T) {
+	store := newBatchStore(nil)
+	ns := newBatcherNamespace(store)
+	if err := ns.Set(context.Background(), "a", user{Name: "a"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the window never ends, so only full batches are sent
+	b := cache.NewBatcher(ns, cache.BatcherConfig{Wait: time.Hour, MaxBatch: 2}, nil)
+
+	values, errs := getAll(b, "a", "b", "c", "d")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	if values["a"] == nil || values["a"].Name != "a" || values["b"] != nil {
+		t.Fatalf("got %v, want a hit for a and misses for the rest", values)
+	}
+
+	batches := store.recorded()
+	if len(batches) != 2 || len(strings.Split(batches[0], ",")) != 2 || len(strings.Split(batches[1], ",")) != 2 {
+		t.Fatalf("got batches %v, want two batches of two keys", batches)
+	}
+}
+
+func TestBatcherWait(t *testing.T) {
+	store := newBatchStore(nil)
+	b := cache.NewBatcher(newBatcherNamespace(store), cache.BatcherConfig{Wait: 50 * time.Millisecond, MaxBatch: 100}, nil)
+
+	start := time.Now()
+	if _, errs := getAll(b, "a", "a", "b"); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	if took := time.Since(start); took < 50*time.Millisecond {
+		t.Fatalf("batch was sent after %v, before the window of 50ms ended", took)
+	}
+
+	// the calls can only end up in different batches if the machine stalled for the whole window
+	if batches := store.recorded(); len(batches) != 1 || batches[0] != "a,b" {
+		t.Fatalf("got batches %v, want a and b in a single batch", batches)
+	}
+}
+
+func TestBatcherError(t *testing.T) {
+	storeErr := errors.New("connection refused")
+	b := cache.NewBatcher(newBatcherNamespace(newBatchStore(storeErr)), cache.BatcherConfig{Wait: time.Hour, MaxBatch: 3}, nil)
+
+	_, errs := getAll(b, "a", "b", "c")
+	if len(errs) != 3 {
+		t.Fatalf("%d of 3 callers got an error", len(errs))
+	}
+
+	for _, err := range errs {
+		if !errors.Is(err, storeErr) {
+			t.Fatalf("got %v, want the error of the store", err)
+		}
+	}
+}
+
+func TestBatcherSwr(t *testing.T) {
+	ns := newBatcherNamespace(memory.New(memory.Config{}))
+	if err := ns.Set(context.Background(), "a", user{Name: "cached"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mu := sync.Mutex{}
+	loads := make([][]string, 0)
+	b := cache.NewBatcher(ns, cache.BatcherConfig{Wait: time.Hour, MaxBatch: 3}, func(keys []string) ([]cache.GetMany[user], error) {
+		mu.Lock()
+		loads = append(loads, keys)
+		mu.Unlock()
+
+		values := make([]cache.GetMany[user], 0, len(keys))
+		for _, key := range keys {
+			values = append(values, cache.GetMany[user]{Key: key, Value: &user{Name: "loaded"}, Found: true})
+		}
+		return values, nil
+	})
+
+	values := make(chan *user, 3)
+	wg := sync.WaitGroup{}
+	for _, key := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			value, err := b.Swr(context.Background(), key)
+			if err != nil {
+				t.Error(err)
+			}
+			values <- value
+		}()
+	}
+	wg.Wait()
+	close(values)
+
+	names := make([]string, 0, 3)
+	for v := range values {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "cached,loaded,loaded" {
+		t.Fatalf("got %v, want the cached value for a and loaded ones for b and c", names)
+	}
+
+	if len(loads) != 1 || len(loads[0]) != 2 {
+		t.Fatalf("origin was called with %v, want b and c in one call", loads)
+	}
+}
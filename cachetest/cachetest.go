@@ -0,0 +1,43 @@
+// Package cachetest has helpers for testing code that uses the cache
+package cachetest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock only moves when it is told to, use it as NamespaceConfig.Clock and memory.Config.Clock
+// to test fresh and stale values without sleeping
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a clock that stands still at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d and returns the new time
+func (c *FakeClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Set moves the clock to now, which may be in the past
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// ConditionalStore can be implemented by stores that support optimistic concurrency.
+// The revision of the written value is already set in value.Revision, stores only compare and persist it.
+type ConditionalStore interface {
+	Store
+	// SetIfAbsent only writes the value if there is no entry for the key yet, otherwise it returns ErrConflict
+	SetIfAbsent(ctx context.Context, namespace types.TNamespace, key string, value types.TValue) error
+	// CompareAndSwap only writes the value if the revision of the entry is expected, otherwise it returns ErrConflict
+	CompareAndSwap(ctx context.Context, namespace types.TNamespace, key string, expected uint64, value types.TValue) error
+}
+
+// Entry is a value together with the revision CompareAndSwap compares against
+type Entry[T any] struct {
+	Key        string
+	Value      *T
+	Revision   uint64
+	FreshUntil time.Time
+	StaleUntil time.Time
+}
+
+// GetEntry reads the key from the last store, which decides conditional writes, so the revision is the current one.
+// Set and SetMany give values a new revision as well, so CompareAndSwap fails once the entry was overwritten.
+func (n Namespace[T]) GetEntry(ctx context.Context, key string) (*Entry[T], bool, error) {
+	ctx, span := telemetry.NewSpan(ctx, "namespace.get-entry")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(n.ns)},
+	)
+
+	if key == "" {
+		return nil, false, ErrEmptyKey
+	}
+
+	value, found, err := n.store.getEntry(ctx, n.ns, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !found || value.Value == nil || n.store.clock.Now().After(value.StaleUntil) {
+		return nil, false, nil
+	}
+
+	return &Entry[T]{
+		Key:        key,
+		Value:      getT[T](value.Value),
+		Revision:   value.Revision,
+		FreshUntil: value.FreshUntil,
+		StaleUntil: value.StaleUntil,
+	}, true, nil
+}
+
+// SetIfAbsent only sets the value if the key does not exist yet and returns its revision,
+// ErrConflict is returned if it does. The last store has to implement ConditionalStore.
+func (n Namespace[T]) SetIfAbsent(ctx context.Context, key string, value T, opts *types.SetOptions) (uint64, error) {
+	ctx, span := telemetry.NewSpan(ctx, "namespace.set-if-absent")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(n.ns)},
+	)
+
+	if key == "" {
+		return 0, ErrEmptyKey
+	}
+
+	return n.store.setConditional(ctx, n.ns, key, &value, opts, nil)
+}
+
+// CompareAndSwap only sets the value if the revision of the entry is still expected and returns the new revision,
+// ErrConflict is returned if the entry changed or does not exist. The last store has to implement ConditionalStore.
+func (n Namespace[T]) CompareAndSwap(ctx context.Context, key string, expected uint64, value T, opts *types.SetOptions) (uint64, error) {
+	ctx, span := telemetry.NewSpan(ctx, "namespace.compare-and-swap")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(n.ns)},
+		telemetry.AttributeKV{Key: "expected", Value: int64(expected)},
+	)
+
+	if key == "" {
+		return 0, ErrEmptyKey
+	}
+
+	return n.store.setConditional(ctx, n.ns, key, &value, opts, &expected)
+}
+
+// authority is the tier conditional writes go to, the last one as that is usually the store shared by all instances
+func (t tieredCache[T]) authority(ns types.TNamespace, op string, key string) (tier, ConditionalStore, error) {
+	if len(t.tiers) == 0 {
+		return tier{}, nil, ErrNoStores
+	}
+
+	last := t.tiers[len(t.tiers)-1]
+	store, ok := Capability[ConditionalStore](last.Store)
+	if !ok || last.DisableWrites {
+		return last, nil, tierError(last, ns, op, []string{key}, ErrUnsupported)
+	}
+
+	return last, store, nil
+}
+
+func (t tieredCache[T]) getEntry(ctx context.Context, ns types.TNamespace, key string) (types.TValue, bool, error) {
+	last, _, err := t.authority(ns, "get", key)
+	if err != nil && !errors.Is(err, ErrUnsupported) {
+		return types.TValue{}, false, err
+	}
+
+	var result T
+	value, found, err := last.Store.Get(ctx, t.ns, key, &result)
+
+	undecodable := errors.Is(err, ErrDecode)
+	if err != nil && !undecodable {
+		return types.TValue{}, false, tierError(last, ns, "get", []string{key}, err)
+	}
+
+	if undecodable || (found && value.Value != nil && t.schema.mismatch(value)) {
+		if value, found = t.upgrade(ctx, ns, last, key, undecodable); !found {
+			return t.invalidEntry(ctx, last, key), false, nil
+		}
+	}
+
+	return value, found, nil
+}
+
+// invalidEntry returns the revision of an entry that is treated as a miss but may still be stored, with Found set
+// if it is. Conditional writes have to replace it with CompareAndSwap, SetIfAbsent would conflict until it expired
+func (t tieredCache[T]) invalidEntry(ctx context.Context, last tier, key string) types.TValue {
+	var raw json.RawMessage
+	value, found, err := last.Store.Get(ctx, t.ns, key, &raw)
+	if err != nil || !found {
+		return types.TValue{}
+	}
+
+	return types.TValue{Key: key, Found: true, Revision: value.Revision}
+}
+
+// setConditional writes the value to the authoritative tier with SetIfAbsent or, if expected is set, with CompareAndSwap.
+// Once that succeeded the faster tiers get the new revision as well.
+func (t tieredCache[T]) setConditional(ctx context.Context, ns types.TNamespace, key string, value *T, opts *types.SetOptions, expected *uint64) (uint64, error) {
+	op := "set-if-absent"
+	if expected != nil {
+		op = "compare-and-swap"
+	}
+
+	last, store, err := t.authority(ns, op, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if !last.breaker.allow() {
+		return 0, tierError(last, ns, op, []string{key}, ErrStoreUnavailable)
+	}
+
+	// a queued write would overwrite the new revision once it is written
+	if t.writeBehind != nil {
+		t.writeBehind.cancel([]string{key})
+	}
+
+	now := t.clock.Now()
+	p := t.pending(key, value, opts, now)
+	// the size limit only decides which tiers keep a copy, the authoritative one has to be written
+	p.size = 0
+	if expected != nil {
+		p.value.Revision = nextRevision(now, *expected+1)
+	}
+	v := last.values([]pendingValue{p}, t.fresh, t.stale)[0]
+
+	start := time.Now()
+	if expected != nil {
+		err = store.CompareAndSwap(ctx, t.ns, key, *expected, v)
+	} else {
+		err = store.SetIfAbsent(ctx, t.ns, key, v)
+	}
+
+	// a conflict means the store works as it should
+	if errors.Is(err, ErrConflict) {
+		last.breaker.record(nil, time.Since(start))
+		return 0, tierError(last, ns, op, []string{key}, err)
+	}
+	last.breaker.record(err, time.Since(start))
+
+	if err != nil {
+		return 0, tierError(last, ns, op, []string{key}, err)
+	}
+
+	// concurrent writes of the key can finish in any order, an older revision must not overwrite a newer one
+	lock := t.revisions.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	faster := writableTiers(t.tiers[:len(t.tiers)-1])
+	if err := fanOut(ctx, ns, "set", t.policy, faster, []string{key}, func(ctx context.Context, tier tier) error {
+		values := tier.values([]pendingValue{p}, t.fresh, t.stale)
+		if len(values) == 0 {
+			return nil
+		}
+
+		var result T
+		current, found, err := tier.Store.Get(ctx, t.ns, key, &result)
+		if err == nil && found && current.Revision >= p.value.Revision {
+			return nil
+		}
+
+		return tier.Store.Set(ctx, t.ns, key, values[0])
+	}); err != nil {
+		// the write itself succeeded, an old revision in a faster tier would only make the next CompareAndSwap fail
+		_ = fanOut(ctx, ns, "remove", WriteBestEffort, t.tiers[:len(t.tiers)-1], []string{key}, func(ctx context.Context, tier tier) error {
+			return tier.Store.Remove(ctx, t.ns, []string{key})
+		})
+	}
+
+	return p.value.Revision, nil
+}
+
+// lastRevision is the last revision handed out by this instance
+var lastRevision atomic.Uint64
+
+// nextRevision returns the revision of a new write, at least min. Revisions are the time of the write in nanoseconds,
+// so they keep increasing across instances and the revision an entry had once never comes back after it was overwritten.
+// Within this instance they are unique even if the clock stands still or goes back
+func nextRevision(now time.Time, min uint64) uint64 {
+	for {
+		last := lastRevision.Load()
+		next := max(uint64(now.UnixNano()), min, last+1)
+		if lastRevision.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// keyLocks serializes work on the same key within this instance without keeping a lock per key
+type keyLocks [64]sync.Mutex
+
+func (l *keyLocks) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return &l[h.Sum32()%uint32(len(l))]
+}
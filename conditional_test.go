@@ -0,0 +1,57 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+func TestCompareAndSwapAfterSet(t *testing.T) {
+	ctx := context.Background()
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{memory.New(memory.Config{})},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+	})
+
+	if err := ns.Set(ctx, "alice", user{Name: "first"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, found, err := ns.GetEntry(ctx, "alice")
+	if err != nil || !found {
+		t.Fatalf("got found %v and %v", found, err)
+	}
+	held := entry.Revision
+
+	revision, err := ns.CompareAndSwap(ctx, "alice", held, user{Name: "second"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if revision <= held {
+		t.Fatalf("CompareAndSwap wrote revision %d, want more than %d", revision, held)
+	}
+
+	// a plain write in between must not bring back the revision that is still held
+	if err := ns.Set(ctx, "alice", user{Name: "third"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ns.CompareAndSwap(ctx, "alice", held, user{Name: "stale"}, nil); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("CompareAndSwap with a revision from before Set, CompareAndSwap and Set got %v, want ErrConflict", err)
+	}
+
+	if _, err := ns.CompareAndSwap(ctx, "alice", revision, user{Name: "stale"}, nil); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("CompareAndSwap with the revision before the last Set got %v, want ErrConflict", err)
+	}
+
+	value, _, err := ns.Get(ctx, "alice")
+	if err != nil || value == nil || value.Name != "third" {
+		t.Fatalf("got %v and %v, want the value of the last Set", value, err)
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// ContextlessStore is the Store interface from before its methods took a context.Context.
+// Stores that still implement it can be used with FromContextless until they are migrated.
+type ContextlessStore interface {
+	Name() string
+
+	CreateCacheKey(namespace types.TNamespace, key string) string
+	Get(namespace types.TNamespace, key string, T any) (value types.TValue, found bool, err error)
+	GetMany(namespace types.TNamespace, keys []string, T any) ([]types.TValue, error)
+	Set(namespace types.TNamespace, key string, value types.TValue) error
+	SetMany(namespace types.TNamespace, values []types.TValue, opts *types.SetOptions) error
+	Remove(namespace types.TNamespace, key []string) error
+}
+
+// FromContextless turns a ContextlessStore into a Store. The store can't be cancelled once a call started,
+// calls with a ctx that is already done return its error without reaching the store.
+func FromContextless(store ContextlessStore) Store {
+	return &contextlessStore{store: store}
+}
+
+type contextlessStore struct {
+	store ContextlessStore
+}
+
+func (c *contextlessStore) Name() string {
+	return c.store.Name()
+}
+
+func (c *contextlessStore) CreateCacheKey(namespace types.TNamespace, key string) string {
+	return c.store.CreateCacheKey(namespace, key)
+}
+
+func (c *contextlessStore) Get(ctx context.Context, namespace types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return types.TValue{}, false, err
+	}
+
+	return c.store.Get(namespace, key, T)
+}
+
+func (c *contextlessStore) GetMany(ctx context.Context, namespace types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.store.GetMany(namespace, keys, T)
+}
+
+func (c *contextlessStore) Set(ctx context.Context, namespace types.TNamespace, key string, value types.TValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.store.Set(namespace, key, value)
+}
+
+func (c *contextlessStore) SetMany(ctx context.Context, namespace types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.store.SetMany(namespace, values, opts)
+}
+
+func (c *contextlessStore) Remove(ctx context.Context, namespace types.TNamespace, keys []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.store.Remove(namespace, keys)
+}
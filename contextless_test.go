@@ -0,0 +1,62 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+	"github.com/steamsets/go-cache/storetest"
+)
+
+// oldStore implements the Store interface as it was before it took a context.Context
+type oldStore struct {
+	store *memory.MemoryStore
+}
+
+func (s oldStore) Name() string {
+	return "old"
+}
+
+func (s oldStore) CreateCacheKey(namespace types.TNamespace, key string) string {
+	return s.store.CreateCacheKey(namespace, key)
+}
+
+func (s oldStore) Get(namespace types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	return s.store.Get(context.Background(), namespace, key, T)
+}
+
+func (s oldStore) GetMany(namespace types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	return s.store.GetMany(context.Background(), namespace, keys, T)
+}
+
+func (s oldStore) Set(namespace types.TNamespace, key string, value types.TValue) error {
+	return s.store.Set(context.Background(), namespace, key, value)
+}
+
+func (s oldStore) SetMany(namespace types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	return s.store.SetMany(context.Background(), namespace, values, opts)
+}
+
+func (s oldStore) Remove(namespace types.TNamespace, keys []string) error {
+	return s.store.Remove(context.Background(), namespace, keys)
+}
+
+func TestFromContextless(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) cache.Store {
+		return cache.FromContextless(oldStore{store: memory.New(memory.Config{})})
+	})
+}
+
+func TestFromContextlessCancelled(t *testing.T) {
+	store := cache.FromContextless(oldStore{store: memory.New(memory.Config{})})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := store.Get(ctx, "ns", "key", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// CounterStore can be implemented by stores that can change a number atomically.
+// Counters are kept as plain numbers, so they can't be read with Get and should use their own namespace.
+type CounterStore interface {
+	Store
+	// Increment adds delta to the counter and returns the new value. A missing or expired counter starts at 0
+	// and expires ttl after it was created, increments don't extend it. A ttl of 0 keeps it forever
+	Increment(ctx context.Context, namespace types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error)
+	// Counter returns the current value, a missing or expired counter is not found
+	Counter(ctx context.Context, namespace types.TNamespace, key string) (int64, bool, error)
+}
+
+// TimeStore can be implemented by stores that have a clock of their own, like the time of a redis server.
+// Instances with skewed clocks still agree on the time as long as they ask the same store
+type TimeStore interface {
+	Time(ctx context.Context) (time.Time, error)
+}
+
+type CounterConfig struct {
+	// Counters only live in the last store, which has to implement CounterStore.
+	// Copies in faster stores could never be updated atomically, so the other stores are ignored
+	Stores []Store
+	// How long a counter lives after its first increment, 0 keeps it until Reset
+	TTL time.Duration
+}
+
+// Counter keeps numbers like view counts or quotas that are changed atomically instead of being overwritten
+type Counter struct {
+	ns    types.TNamespace
+	store Store
+	ttl   time.Duration
+}
+
+func NewCounter(ns types.TNamespace, cfg CounterConfig) Counter {
+	c := Counter{
+		ns:  ns,
+		ttl: cfg.TTL,
+	}
+
+	if len(cfg.Stores) > 0 {
+		c.store = cfg.Stores[len(cfg.Stores)-1]
+	}
+
+	return c
+}
+
+func (c Counter) counterStore(op string, key string) (CounterStore, error) {
+	if c.store == nil {
+		return nil, ErrNoStores
+	}
+
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+
+	store, ok := Capability[CounterStore](c.store)
+	if !ok {
+		return nil, NewStoreError(c.store.Name(), c.ns, op, []string{key}, ErrUnsupported, ErrUnsupported)
+	}
+
+	return store, nil
+}
+
+// Incr adds delta to the counter and returns the new value
+func (c Counter) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	ctx, span := telemetry.NewSpan(ctx, "counter.incr")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(c.ns)},
+		telemetry.AttributeKV{Key: "delta", Value: delta},
+	)
+
+	store, err := c.counterStore("increment", key)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := store.Increment(ctx, c.ns, key, delta, c.ttl)
+	if err != nil {
+		telemetry.RecordError(span, err)
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Decr subtracts delta from the counter and returns the new value, memcached counters stop at 0
+func (c Counter) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.Incr(ctx, key, -delta)
+}
+
+// Get returns the current value, a counter that does not exist is 0
+func (c Counter) Get(ctx context.Context, key string) (int64, error) {
+	ctx, span := telemetry.NewSpan(ctx, "counter.get")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(c.ns)},
+	)
+
+	store, err := c.counterStore("counter", key)
+	if err != nil {
+		return 0, err
+	}
+
+	value, _, err := store.Counter(ctx, c.ns, key)
+	if err != nil {
+		telemetry.RecordError(span, err)
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Reset removes the counter, the next Incr starts at 0 with a new TTL
+func (c Counter) Reset(ctx context.Context, key string) error {
+	ctx, span := telemetry.NewSpan(ctx, "counter.reset")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(c.ns)},
+	)
+
+	store, err := c.counterStore("remove", key)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Remove(ctx, c.ns, []string{key}); err != nil {
+		telemetry.RecordError(span, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/rueidis"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/cachetest"
+	"github.com/steamsets/go-cache/store/memory"
+	"github.com/steamsets/go-cache/store/redis"
+)
+
+// counterStores returns the stores counters are tested against and a way to let time pass for each of them
+func counterStores() map[string]func(t *testing.T) (cache.Store, func(time.Duration)) {
+	return map[string]func(t *testing.T) (cache.Store, func(time.Duration)){
+		"memory": func(t *testing.T) (cache.Store, func(time.Duration)) {
+			clock := cachetest.NewFakeClock(time.Now())
+			return memory.New(memory.Config{Clock: clock}), func(d time.Duration) { clock.Advance(d) }
+		},
+		"redis": func(t *testing.T) (cache.Store, func(time.Duration)) {
+			server := miniredis.RunT(t)
+			client, err := rueidis.NewClient(rueidis.ClientOption{
+				InitAddress:       []string{server.Addr()},
+				DisableCache:      true,
+				ForceSingleClient: true,
+			})
+			if err != nil {
+				t.Skipf("redis can't be reached: %v", err)
+			}
+			t.Cleanup(client.Close)
+
+			return redis.New(redis.Config{Client: client}), server.FastForward
+		},
+	}
+}
+
+func TestCounter(t *testing.T) {
+	for name, newStore := range counterStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store, advance := newStore(t)
+			views := cache.NewCounter("views", cache.CounterConfig{
+				Stores: []cache.Store{memory.New(memory.Config{}), store},
+				TTL:    time.Minute,
+			})
+
+			if value, err := views.Get(ctx, "post"); err != nil || value != 0 {
+				t.Fatalf("got %d and %v for a missing counter, want 0", value, err)
+			}
+
+			if value, err := views.Incr(ctx, "post", 5); err != nil || value != 5 {
+				t.Fatalf("got %d and %v, want 5", value, err)
+			}
+
+			if value, err := views.Decr(ctx, "post", 2); err != nil || value != 3 {
+				t.Fatalf("got %d and %v, want 3", value, err)
+			}
+
+			// increments don't extend the ttl of the first one
+			advance(40 * time.Second)
+			if _, err := views.Incr(ctx, "post", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			if value, err := views.Get(ctx, "post"); err != nil || value != 4 {
+				t.Fatalf("got %d and %v, want 4", value, err)
+			}
+
+			advance(30 * time.Second)
+			if value, err := views.Get(ctx, "post"); err != nil || value != 0 {
+				t.Fatalf("got %d and %v after the ttl, want the counter to be gone", value, err)
+			}
+
+			if value, err := views.Incr(ctx, "post", 1); err != nil || value != 1 {
+				t.Fatalf("got %d and %v, want an expired counter to start at 0 again", value, err)
+			}
+
+			if err := views.Reset(ctx, "post"); err != nil {
+				t.Fatal(err)
+			}
+
+			if value, err := views.Get(ctx, "post"); err != nil || value != 0 {
+				t.Fatalf("got %d and %v after Reset, want 0", value, err)
+			}
+		})
+	}
+}
+
+func TestCounterConcurrentIncr(t *testing.T) {
+	for name, newStore := range counterStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store, _ := newStore(t)
+			views := cache.NewCounter("views", cache.CounterConfig{Stores: []cache.Store{store}})
+
+			wg := sync.WaitGroup{}
+			for range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := views.Incr(ctx, "post", 2); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if value, err := views.Get(ctx, "post"); err != nil || value != 100 {
+				t.Fatalf("got %d and %v, want every increment to be counted", value, err)
+			}
+		})
+	}
+}
+
+func TestCounterUnsupportedStore(t *testing.T) {
+	// embedding only the Store interface hides the counters of the memory store
+	plain := struct{ cache.Store }{memory.New(memory.Config{})}
+	views := cache.NewCounter("views", cache.CounterConfig{Stores: []cache.Store{plain}})
+
+	if _, err := views.Incr(context.Background(), "post", 1); !errors.Is(err, cache.ErrUnsupported) {
+		t.Fatalf("got %v, want ErrUnsupported for a store without counters", err)
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is handed to callers that were waiting on a load from the origin
+// which panicked, the caller that started the load re-panics with it instead.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("cache: load from origin panicked: %v\n\n%s", p.Value, p.Stack)
+}
+
+// flightGroup makes sure that only one load per key is running at a time,
+// every other caller asking for the same key waits for that load and gets the same result.
+type flightGroup[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[V]
+}
+
+type flightCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+	// callers that joined the load instead of starting it, guarded by the mutex of the group
+	joined int
+}
+
+func newFlightGroup[V any]() *flightGroup[V] {
+	return &flightGroup[V]{
+		calls: make(map[string]*flightCall[V]),
+	}
+}
+
+// do runs fn for the key unless a load for it is already in flight, in which case it waits for that one.
+// The load itself is not bound to ctx, so a caller giving up does not cancel it for everybody else.
+func (g *flightGroup[V]) do(ctx context.Context, key string, fn func() (V, error)) (V, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		call.joined++
+		g.mu.Unlock()
+		return call.wait(ctx)
+	}
+
+	call := &flightCall[V]{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	go g.run(key, call, fn)
+
+	value, err := call.wait(ctx)
+
+	// The panic belongs to the caller that started the load, everybody else only sees the error.
+	if p, ok := err.(*PanicError); ok {
+		panic(p)
+	}
+
+	return value, err
+}
+
+func (g *flightGroup[V]) run(key string, call *flightCall[V], fn func() (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		close(call.done)
+	}()
+
+	call.value, call.err = fn()
+}
+
+func (c *flightCall[V]) wait(ctx context.Context) (V, error) {
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		var value V
+		return value, ctx.Err()
+	}
+}
+
+// doMany is the batch version of do, keys that are already in flight are waited for
+// and only the remaining ones are handed to fn in a single call.
+// Every other caller waiting on one of those keys gets its value from that call as well.
+func (g *flightGroup[V]) doMany(ctx context.Context, keys []string, fn func([]string) (map[string]V, error)) (map[string]V, error) {
+	calls := make(map[string]*flightCall[V], len(keys))
+	owned := make(map[string]*flightCall[V])
+	keysToLoad := make([]string, 0)
+
+	g.mu.Lock()
+	for _, key := range keys {
+		if _, ok := calls[key]; ok {
+			continue
+		}
+
+		if call, ok := g.calls[key]; ok {
+			call.joined++
+			calls[key] = call
+			continue
+		}
+
+		call := &flightCall[V]{done: make(chan struct{})}
+		g.calls[key] = call
+		calls[key] = call
+		owned[key] = call
+		keysToLoad = append(keysToLoad, key)
+	}
+	g.mu.Unlock()
+
+	if len(keysToLoad) > 0 {
+		go g.runMany(keysToLoad, owned, fn)
+	}
+
+	values := make(map[string]V, len(calls))
+	for key, call := range calls {
+		value, err := call.wait(ctx)
+		if err != nil {
+			// Same as in do, only the caller that started the load re-panics.
+			if p, ok := err.(*PanicError); ok && owned[key] != nil {
+				panic(p)
+			}
+
+			return nil, err
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (g *flightGroup[V]) runMany(keys []string, calls map[string]*flightCall[V], fn func([]string) (map[string]V, error)) {
+	defer func() {
+		var panicErr error
+		if r := recover(); r != nil {
+			panicErr = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+
+		g.mu.Lock()
+		for key, call := range calls {
+			if panicErr != nil {
+				call.err = panicErr
+			}
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+
+		for _, call := range calls {
+			close(call.done)
+		}
+	}()
+
+	values, err := fn(keys)
+	for key, call := range calls {
+		// keys the origin did not return just end up with the zero value
+		call.value = values[key]
+		call.err = err
+	}
+}
@@ -0,0 +1,291 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupDoSingleLoad(t *testing.T) {
+	g := newFlightGroup[int]()
+	loadErr := errors.New("origin failed")
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	const callers = 500
+	type result struct {
+		value int
+		err   error
+	}
+	results := make(chan result, callers)
+
+	fn := func() (int, error) {
+		calls.Add(1)
+		close(started)
+		<-release
+		return 42, loadErr
+	}
+
+	// the first caller owns the load, everybody else joins while it is blocked
+	go func() {
+		value, err := g.do(context.Background(), "key", fn)
+		results <- result{value, err}
+	}()
+	<-started
+
+	wg := sync.WaitGroup{}
+	for range callers - 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := g.do(context.Background(), "key", fn)
+			results <- result{value, err}
+		}()
+	}
+
+	// every waiter has to join before the load finishes, otherwise it would start a new one
+	waitJoined(t, g, callers-1)
+	close(release)
+	wg.Wait()
+
+	for range callers {
+		r := <-results
+		if r.value != 42 || !errors.Is(r.err, loadErr) {
+			t.Fatalf("got %d, %v, want 42, %v", r.value, r.err, loadErr)
+		}
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("origin was called %d times, want 1", n)
+	}
+}
+
+func TestFlightGroupDoWaiterCancelled(t *testing.T) {
+	g := newFlightGroup[string]()
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	owner := make(chan error, 1)
+	go func() {
+		_, err := g.do(context.Background(), "key", func() (string, error) {
+			close(started)
+			<-release
+			return "value", nil
+		})
+		owner <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := g.do(ctx, "key", func() (string, error) {
+			t.Error("waiter started a second load")
+			return "", nil
+		})
+		waiter <- err
+	}()
+
+	cancel()
+	select {
+	case err := <-waiter:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled waiter got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled waiter kept waiting for the load")
+	}
+
+	other := make(chan string, 1)
+	go func() {
+		value, _ := g.do(context.Background(), "key", func() (string, error) {
+			return "second load", nil
+		})
+		other <- value
+	}()
+
+	// the cancelled waiter and the other one joined, the load can finish
+	waitJoined(t, g, 2)
+	close(release)
+
+	if err := <-owner; err != nil {
+		t.Fatalf("owner got %v after a waiter was cancelled", err)
+	}
+
+	if value := <-other; value != "value" {
+		t.Fatalf("other waiter got %q, want the shared value", value)
+	}
+}
+
+func TestFlightGroupDoPanic(t *testing.T) {
+	g := newFlightGroup[int]()
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	owner := make(chan any, 1)
+	go func() {
+		defer func() {
+			owner <- recover()
+		}()
+
+		g.do(context.Background(), "key", func() (int, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := g.do(context.Background(), "key", func() (int, error) {
+			return 0, nil
+		})
+		waiter <- err
+	}()
+
+	waitJoined(t, g, 1)
+	close(release)
+
+	recovered := <-owner
+	panicErr, ok := recovered.(*PanicError)
+	if !ok {
+		t.Fatalf("owner recovered %T, want *PanicError", recovered)
+	}
+
+	if panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("PanicError has value %v and %d bytes of stack", panicErr.Value, len(panicErr.Stack))
+	}
+
+	err := <-waiter
+	if err == nil {
+		t.Fatal("waiter got no error from a panicking load")
+	}
+
+	if !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("waiter got %v, want the panic", err)
+	}
+}
+
+func TestFlightGroupDoManyOverlapping(t *testing.T) {
+	g := newFlightGroup[string]()
+
+	mu := sync.Mutex{}
+	loaded := make(map[string]int)
+	release := make(chan struct{})
+
+	fn := func(keys []string) (map[string]string, error) {
+		mu.Lock()
+		for _, key := range keys {
+			loaded[key]++
+		}
+		mu.Unlock()
+
+		<-release
+
+		values := make(map[string]string, len(keys))
+		for _, key := range keys {
+			values[key] = "value-" + key
+		}
+		return values, nil
+	}
+
+	sets := [][]string{
+		{"a", "b", "c"},
+		{"b", "c", "d"},
+		{"c", "d", "e", "a"},
+		{"e", "e", "f"},
+	}
+
+	const rounds = 100
+	type result struct {
+		keys   []string
+		values map[string]string
+		err    error
+	}
+	results := make(chan result, rounds*len(sets))
+
+	// every call either starts or joins the load of each distinct key it asks for,
+	// the load has to wait until all of them did so no key is loaded twice
+	joins := -6
+	for _, keys := range sets {
+		distinct := make(map[string]struct{})
+		for _, key := range keys {
+			distinct[key] = struct{}{}
+		}
+		joins += rounds * len(distinct)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := range rounds * len(sets) {
+		keys := sets[i%len(sets)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values, err := g.doMany(context.Background(), keys, fn)
+			results <- result{keys, values, err}
+		}()
+	}
+
+	waitJoined(t, g, joins)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for r := range results {
+		if r.err != nil {
+			t.Fatalf("doMany %v: %v", r.keys, r.err)
+		}
+
+		for _, key := range r.keys {
+			if r.values[key] != "value-"+key {
+				t.Fatalf("doMany %v got %q for %q", r.keys, r.values[key], key)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(loaded))
+	for key, n := range loaded {
+		keys = append(keys, key)
+		if n != 1 {
+			t.Errorf("%q was loaded %d times, want 1", key, n)
+		}
+	}
+
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "a,b,c,d,e,f" {
+		t.Errorf("loaded %v, want a to f", keys)
+	}
+}
+
+// waitJoined blocks until n callers joined loads of g that are still in flight
+func waitJoined[V any](t *testing.T, g *flightGroup[V], n int) {
+	t.Helper()
+
+	joined := func() int {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		sum := 0
+		for _, call := range g.calls {
+			sum += call.joined
+		}
+		return sum
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for joined() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d callers joined the load", joined(), n)
+		}
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+var (
+	// The namespace has no stores configured
+	ErrNoStores = errors.New("no stores found")
+	ErrEmptyKey = errors.New("key is empty")
+	// A KeyEncoder could not turn the key into a string
+	ErrInvalidKey = errors.New("invalid key")
+	ErrNoKeys     = errors.New("no keys provided")
+	ErrNoValues   = errors.New("no values provided")
+	// Fetch, FetchMany or a refresh was called without a loader
+	ErrNoLoader = errors.New("no loader registered")
+	// A value was found but could not be decoded into the type of the namespace
+	ErrDecode = errors.New("failed to decode value")
+	// The store could not be reached, e.g. the connection was refused or dropped
+	ErrStoreUnavailable = errors.New("store unavailable")
+	// The store did not answer in time
+	ErrTimeout = errors.New("store timed out")
+	// A conditional write lost against another write, read the entry again and retry
+	ErrConflict = errors.New("revision conflict")
+	// The store does not support the operation, e.g. conditional writes
+	ErrUnsupported = errors.New("operation not supported by store")
+)
+
+// StoreError is the error of a single store call, use errors.Is with the errors above to find out what went wrong
+type StoreError struct {
+	Store     string
+	Namespace types.TNamespace
+	// The store method that failed, e.g. get or set-many
+	Op string
+	// Index of the store in NamespaceConfig.Tiers or Stores, -1 if the error did not come from a tiered operation
+	Tier int
+	Keys []string
+	Err  error
+}
+
+// NewStoreError is used by stores and middlewares to wrap err, kind is one of the errors above and can be nil.
+// Context deadlines are reported as ErrTimeout.
+func NewStoreError(store string, ns types.TNamespace, op string, keys []string, kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var storeErr *StoreError
+	if errors.As(err, &storeErr) {
+		return err
+	}
+
+	if kind == nil && errors.Is(err, context.DeadlineExceeded) {
+		kind = ErrTimeout
+	}
+
+	if kind != nil && !errors.Is(err, kind) {
+		err = fmt.Errorf("%w: %w", kind, err)
+	}
+
+	return &StoreError{
+		Store:     store,
+		Namespace: ns,
+		Op:        op,
+		Tier:      -1,
+		Keys:      keys,
+		Err:       err,
+	}
+}
+
+// tierError wraps the error of a store within a tiered operation, keeping what the store already reported
+func tierError(t tier, ns types.TNamespace, op string, keys []string, err error) *StoreError {
+	var storeErr *StoreError
+	if errors.As(err, &storeErr) {
+		e := *storeErr
+		e.Tier = t.index
+		return &e
+	}
+
+	e := NewStoreError(t.Store.Name(), ns, op, keys, nil, err).(*StoreError)
+	e.Tier = t.index
+	return e
+}
+
+func (e *StoreError) Error() string {
+	store := e.Store
+	if e.Tier >= 0 {
+		store = fmt.Sprintf("%s (tier %d)", e.Store, e.Tier)
+	}
+
+	return fmt.Sprintf("%s %s failed in namespace %s for key(s) %s: %v", store, e.Op, e.Namespace, strings.Join(e.Keys, ","), e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError holds the errors of all stores that failed during a tiered operation
+type MultiError struct {
+	Errors []*StoreError
+}
+
+func (e *MultiError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e *MultiError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
@@ -0,0 +1,80 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+var errRefused = errors.New("connection refused")
+
+func TestNewStoreError(t *testing.T) {
+	if err := cache.NewStoreError("redis", "ns", "get", []string{"key"}, cache.ErrStoreUnavailable, nil); err != nil {
+		t.Fatalf("got %v for a nil error, want nil", err)
+	}
+
+	err := cache.NewStoreError("redis", "ns", "get", []string{"key"}, cache.ErrStoreUnavailable, errRefused)
+	if !errors.Is(err, cache.ErrStoreUnavailable) || !errors.Is(err, errRefused) {
+		t.Fatalf("got %v, want both the kind and the error of the store", err)
+	}
+
+	var storeErr *cache.StoreError
+	if !errors.As(err, &storeErr) {
+		t.Fatalf("got %T, want *cache.StoreError", err)
+	}
+
+	if storeErr.Store != "redis" || storeErr.Namespace != "ns" || storeErr.Op != "get" || storeErr.Tier != -1 || storeErr.Keys[0] != "key" {
+		t.Fatalf("got %+v, want the store, namespace, op and keys it was created with", storeErr)
+	}
+
+	// stores and middlewares wrapping each other keep the first StoreError
+	wrapped := cache.NewStoreError("timeout", "other", "set", nil, cache.ErrTimeout, fmt.Errorf("wrapped: %w", err))
+	if !errors.As(wrapped, &storeErr) || storeErr.Store != "redis" || errors.Is(wrapped, cache.ErrTimeout) {
+		t.Fatalf("got %v, want the StoreError of the store without another kind", wrapped)
+	}
+
+	deadline := cache.NewStoreError("redis", "ns", "get", []string{"key"}, nil, context.DeadlineExceeded)
+	if !errors.Is(deadline, cache.ErrTimeout) {
+		t.Fatalf("got %v, want context deadlines to be reported as ErrTimeout", deadline)
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	ctx := context.Background()
+	broken := &brokenStore{MemoryStore: memory.New(memory.Config{})}
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{memory.New(memory.Config{}), broken, broken},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+	})
+
+	err := ns.Set(ctx, "alice", user{Name: "alice"}, nil)
+
+	var multi *cache.MultiError
+	if !errors.As(err, &multi) {
+		t.Fatalf("got %T, want *cache.MultiError", err)
+	}
+
+	if len(multi.Errors) != 2 || multi.Errors[0].Tier != 1 || multi.Errors[1].Tier != 2 {
+		t.Fatalf("got %v, want the errors of tier 1 and 2", err)
+	}
+
+	if multi.Errors[0].Namespace != "user" || multi.Errors[0].Op != "set" || multi.Errors[0].Keys[0] != "alice" {
+		t.Fatalf("got %+v, want the namespace, op and key of the set", multi.Errors[0])
+	}
+
+	// errors.As finds the StoreError of the first failed tier through the MultiError
+	var storeErr *cache.StoreError
+	if !errors.As(err, &storeErr) || storeErr.Tier != 1 {
+		t.Fatalf("got %v, want the StoreError of tier 1", err)
+	}
+
+	if errors.Is(err, cache.ErrDecode) {
+		t.Fatalf("got %v, errors.Is matched an error none of the stores returned", err)
+	}
+}
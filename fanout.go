@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// WritePolicy decides when a write or remove that goes to multiple stores counts as successful
+type WritePolicy int
+
+const (
+	// Every store has to succeed, this is the default. Stores with an open circuit are skipped,
+	// the write still fails if all of them are
+	WriteAll WritePolicy = iota
+	// Succeeds as long as at least one store succeeded
+	WriteBestEffort
+	// More than half of the stores have to succeed, stores with an open circuit count as failed
+	WriteQuorum
+)
+
+// fanOut runs fn against all tiers at the same time and decides based on the policy if that was a success
+func fanOut(ctx context.Context, ns types.TNamespace, operation string, policy WritePolicy, tiers []tier, keys []string, fn func(context.Context, tier) error) error {
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	errs := make([]*StoreError, 0)
+	// only appended to by this goroutine, the ones of the tiers append to errs under mu
+	skipped := make([]*StoreError, 0)
+
+	for _, t := range tiers {
+		// unhealthy tiers are skipped, a store that is down must not fail every write of WriteAll,
+		// but the write did not reach them so they don't count towards a quorum either
+		if !t.breaker.allow() {
+			skipped = append(skipped, tierError(t, ns, operation, keys, ErrStoreUnavailable))
+			continue
+		}
+
+		wg.Add(1)
+		go func(t tier) {
+			defer wg.Done()
+
+			ctx, span := telemetry.NewSpan(ctx, t.Store.Name()+"."+operation)
+			defer span.End()
+			telemetry.WithAttributes(span,
+				telemetry.AttributeKV{Key: "keys", Value: keys},
+				telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+				telemetry.AttributeKV{Key: "tier", Value: t.index},
+			)
+
+			start := time.Now()
+			err := fn(ctx, t)
+			t.breaker.record(err, time.Since(start))
+
+			if err != nil {
+				telemetry.RecordError(span, err)
+
+				mu.Lock()
+				errs = append(errs, tierError(t, ns, operation, keys, err))
+				mu.Unlock()
+			}
+		}(t)
+	}
+
+	wg.Wait()
+
+	failed := len(errs)
+	succeeded := len(tiers) - failed - len(skipped)
+	if failed+len(skipped) == 0 {
+		return nil
+	}
+
+	errs = append(errs, skipped...)
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Tier < errs[j].Tier
+	})
+
+	switch policy {
+	case WriteAll:
+		// skipped tiers are left out, as long as one of the tiers was written
+		if failed == 0 && succeeded > 0 {
+			return nil
+		}
+	case WriteBestEffort:
+		if succeeded > 0 {
+			return nil
+		}
+	case WriteQuorum:
+		if succeeded > len(tiers)/2 {
+			return nil
+		}
+	}
+
+	return &MultiError{Errors: errs}
+}
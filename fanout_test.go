@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// namedStore only has a name, fanOut never calls the store itself
+type namedStore struct {
+	Store
+	name string
+}
+
+func (s namedStore) Name() string {
+	return s.name
+}
+
+// fanOutTiers returns healthy tiers followed by tiers whose circuit is open
+func fanOutTiers(healthy int, open int) []tier {
+	tiers := make([]tier, 0, healthy+open)
+	for i := range healthy + open {
+		t := tier{index: i, Tier: Tier{Store: namedStore{name: "store"}}}
+		if i >= healthy {
+			t.breaker = &circuitBreaker{
+				cfg:      CircuitBreakerConfig{Cooldown: time.Hour},
+				store:    t.Store,
+				tier:     i,
+				state:    CircuitOpen,
+				openedAt: time.Now(),
+			}
+		}
+
+		tiers = append(tiers, t)
+	}
+
+	return tiers
+}
+
+func TestFanOutOpenCircuits(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  WritePolicy
+		healthy int
+		open    int
+		wantErr bool
+	}{
+		{"all open", WriteAll, 0, 2, true},
+		{"one open", WriteAll, 1, 1, false},
+		{"best effort all open", WriteBestEffort, 0, 2, true},
+		{"best effort one healthy", WriteBestEffort, 1, 2, false},
+		{"quorum of all tiers", WriteQuorum, 2, 1, false},
+		{"no quorum of all tiers", WriteQuorum, 1, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fanOut(context.Background(), "ns", "set", tt.policy, fanOutTiers(tt.healthy, tt.open), []string{"key"}, func(context.Context, tier) error {
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrStoreUnavailable) {
+				t.Fatalf("got %v, want ErrStoreUnavailable for the open circuits", err)
+			}
+		})
+	}
+}
+
+// runs the tiers of fanOut at the same time as the open circuits are skipped, run with -race
+func TestFanOutOpenAndFailingTiers(t *testing.T) {
+	storeErr := errors.New("connection refused")
+
+	for range 100 {
+		tiers := fanOutTiers(2, 2)
+		tiers = append(tiers, fanOutTiers(2, 2)...)
+		for i := range tiers {
+			tiers[i].index = i
+		}
+
+		err := fanOut(context.Background(), "ns", "set", WriteAll, tiers, []string{"key"}, func(context.Context, tier) error {
+			return storeErr
+		})
+
+		var multi *MultiError
+		if !errors.As(err, &multi) || len(multi.Errors) != len(tiers) {
+			t.Fatalf("got %v, want an error for each of the %d tiers", err, len(tiers))
+		}
+
+		for i, e := range multi.Errors {
+			if e.Tier != i {
+				t.Fatalf("error %d is of tier %d, want them sorted by tier", i, e.Tier)
+			}
+		}
+
+		if !errors.Is(err, storeErr) || !errors.Is(err, ErrStoreUnavailable) {
+			t.Fatalf("got %v, want the errors of the failing tiers and the open circuits", err)
+		}
+	}
+}
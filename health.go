@@ -0,0 +1,313 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// Pinger can be implemented by stores so an open circuit can check if the store is back by itself
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type CircuitState int
+
+const (
+	// The store is used as usual
+	CircuitClosed CircuitState = iota
+	// The store is skipped for reads and writes, see WritePolicy for how skipped writes count
+	CircuitOpen
+	// A single call is let through to check if the store recovered
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitBreakerConfig struct {
+	// Share of failed calls within Window that opens the circuit, defaults to 0.5
+	ErrorRate float64
+	// Amount of calls within Window before the ErrorRate is checked, defaults to 10
+	MinRequests int
+	// Calls are counted over this window, defaults to 10 seconds
+	Window time.Duration
+	// Calls slower than this count as failed, 0 disables it
+	SlowCall time.Duration
+	// How long an open circuit skips the store before a single call is let through again, defaults to 5 seconds
+	Cooldown time.Duration
+	// If the store implements Pinger it is pinged this often while the circuit is open, defaults to 1 second
+	PingInterval time.Duration
+	// Called on every state change of any store
+	OnStateChange func(CircuitStateChange)
+}
+
+type CircuitStateChange struct {
+	Namespace types.TNamespace
+	Store     string
+	Tier      int
+	From      CircuitState
+	To        CircuitState
+}
+
+type TierHealth struct {
+	Store string
+	Tier  int
+	State CircuitState
+}
+
+// circuitBreaker keeps track of the error rate and latency of a single tier,
+// all methods can be called on nil in which case the tier is always used.
+type circuitBreaker struct {
+	// stops pinging the store, it is the ctx of the namespace
+	ctx   context.Context
+	cfg   CircuitBreakerConfig
+	ns    types.TNamespace
+	store Store
+	tier  int
+
+	mu          sync.Mutex
+	state       CircuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	pinging     bool
+}
+
+func newCircuitBreaker(ctx context.Context, ns types.TNamespace, t tier, cfg CircuitBreakerConfig) *circuitBreaker {
+	if cfg.ErrorRate <= 0 {
+		cfg.ErrorRate = 0.5
+	}
+
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 5 * time.Second
+	}
+
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = time.Second
+	}
+
+	return &circuitBreaker{
+		ctx:         ctx,
+		cfg:         cfg,
+		ns:          ns,
+		store:       t.Store,
+		tier:        t.index,
+		windowStart: time.Now(),
+	}
+}
+
+// allow reports whether the tier should be used for the next call
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	var change *CircuitStateChange
+	defer func() {
+		b.mu.Unlock()
+		b.notify(change)
+	}()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cfg.Cooldown {
+			return false
+		}
+
+		change = b.transition(CircuitHalfOpen)
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record counts the outcome of a call that was allowed before
+func (b *circuitBreaker) record(err error, took time.Duration) {
+	if b == nil {
+		return
+	}
+
+	failed := err != nil || (b.cfg.SlowCall > 0 && took > b.cfg.SlowCall)
+
+	b.mu.Lock()
+	var change *CircuitStateChange
+	defer func() {
+		b.mu.Unlock()
+		b.notify(change)
+	}()
+
+	now := time.Now()
+
+	switch b.state {
+	case CircuitHalfOpen:
+		b.probing = false
+		if failed {
+			change = b.open(now)
+			return
+		}
+
+		change = b.transition(CircuitClosed)
+		b.reset(now)
+	case CircuitClosed:
+		if now.Sub(b.windowStart) > b.cfg.Window {
+			b.reset(now)
+		}
+
+		b.requests++
+		if failed {
+			b.failures++
+		}
+
+		if b.requests >= b.cfg.MinRequests && float64(b.failures)/float64(b.requests) >= b.cfg.ErrorRate {
+			change = b.open(now)
+		}
+	}
+}
+
+func (b *circuitBreaker) current() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *circuitBreaker) reset(now time.Time) {
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+}
+
+// skips reports whether failed reads of the tier are treated as misses, which is only the case while the circuit is open.
+// Otherwise the error is returned like without a circuit breaker
+func (b *circuitBreaker) skips() bool {
+	return b.current() == CircuitOpen
+}
+
+// open has to be called with the lock held
+func (b *circuitBreaker) open(now time.Time) *CircuitStateChange {
+	b.openedAt = now
+	change := b.transition(CircuitOpen)
+
+	if pinger, ok := Capability[Pinger](b.store); ok && !b.pinging {
+		b.pinging = true
+		go b.ping(pinger)
+	}
+
+	return change
+}
+
+// transition has to be called with the lock held, the returned change is passed to notify once it is released
+func (b *circuitBreaker) transition(to CircuitState) *CircuitStateChange {
+	if b.state == to {
+		return nil
+	}
+
+	change := &CircuitStateChange{
+		Namespace: b.ns,
+		Store:     b.store.Name(),
+		Tier:      b.tier,
+		From:      b.state,
+		To:        to,
+	}
+	b.state = to
+
+	return change
+}
+
+func (b *circuitBreaker) notify(change *CircuitStateChange) {
+	if change == nil {
+		return
+	}
+
+	_, span := telemetry.NewSpan(context.Background(), "tiered.circuit-state-change")
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "namespace", Value: string(change.Namespace)},
+		telemetry.AttributeKV{Key: "store", Value: change.Store},
+		telemetry.AttributeKV{Key: "tier", Value: change.Tier},
+		telemetry.AttributeKV{Key: "from", Value: change.From.String()},
+		telemetry.AttributeKV{Key: "to", Value: change.To.String()},
+	)
+	span.End()
+
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(*change)
+	}
+}
+
+// ping probes the store while the circuit is open and lets the next call through as soon as it answers.
+// It stops once the namespace ctx is done
+func (b *circuitBreaker) ping(pinger Pinger) {
+	ticker := time.NewTicker(b.cfg.PingInterval)
+	defer ticker.Stop()
+
+	defer func() {
+		b.mu.Lock()
+		b.pinging = false
+		b.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if b.current() != CircuitOpen {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(b.ctx, b.cfg.PingInterval)
+		err := pinger.Ping(ctx)
+		cancel()
+
+		if err != nil {
+			continue
+		}
+
+		b.mu.Lock()
+		var change *CircuitStateChange
+		if b.state == CircuitOpen {
+			change = b.transition(CircuitHalfOpen)
+		}
+		b.mu.Unlock()
+		b.notify(change)
+
+		return
+	}
+}
@@ -0,0 +1,190 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+// brokenStore fails every read, write and ping
+type brokenStore struct {
+	*memory.MemoryStore
+	pings atomic.Int32
+}
+
+func (s *brokenStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	return types.TValue{}, false, errors.New("connection refused")
+}
+
+func (s *brokenStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	return errors.New("connection refused")
+}
+
+func (s *brokenStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	return errors.New("connection refused")
+}
+
+func (s *brokenStore) Ping(ctx context.Context) error {
+	s.pings.Add(1)
+	return errors.New("connection refused")
+}
+
+func TestCircuitBreakerReadErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broken := &brokenStore{MemoryStore: memory.New(memory.Config{})}
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{broken, memory.New(memory.Config{})},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+		CircuitBreaker: &cache.CircuitBreakerConfig{
+			MinRequests:  2,
+			PingInterval: time.Hour,
+		},
+	})
+
+	// the circuit is still closed, so the error is returned like without a circuit breaker
+	if _, _, err := ns.Get(ctx, "alice"); err == nil {
+		t.Fatal("error of a store with a closed circuit was swallowed")
+	}
+
+	// this one opens the circuit, from now on the store is a miss
+	if _, found, err := ns.Get(ctx, "alice"); err != nil || found {
+		t.Fatalf("got found %v and %v once the circuit opened, want a miss", found, err)
+	}
+
+	if state := ns.Health()[0].State; state != cache.CircuitOpen {
+		t.Fatalf("circuit is %v, want open", state)
+	}
+}
+
+func TestCircuitBreakerPingStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	broken := &brokenStore{MemoryStore: memory.New(memory.Config{})}
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{broken},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+		CircuitBreaker: &cache.CircuitBreakerConfig{
+			MinRequests:  1,
+			Cooldown:     time.Hour,
+			PingInterval: 5 * time.Millisecond,
+		},
+	})
+
+	ns.Get(ctx, "alice")
+
+	deadline := time.Now().Add(time.Second)
+	for broken.pings.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("store with an open circuit was not pinged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+
+	pings := broken.pings.Load()
+	time.Sleep(50 * time.Millisecond)
+
+	if n := broken.pings.Load(); n != pings {
+		t.Fatalf("store was pinged %d more times after the namespace ctx was cancelled", n-pings)
+	}
+}
+
+func TestCircuitBreakerOpenServesRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fast := memory.New(memory.Config{})
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{fast, &brokenStore{MemoryStore: memory.New(memory.Config{})}},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+		CircuitBreaker: &cache.CircuitBreakerConfig{
+			MinRequests:  1,
+			Cooldown:     time.Hour,
+			PingInterval: time.Hour,
+		},
+	})
+
+	// opens the circuit of the broken store
+	ns.Get(ctx, "alice")
+	if state := ns.Health()[1].State; state != cache.CircuitOpen {
+		t.Fatalf("circuit is %v, want open", state)
+	}
+
+	loads := 0
+	load := func(key string) (*user, error) {
+		loads++
+		return &user{Name: key}, nil
+	}
+
+	for range 2 {
+		value, err := ns.Swr(ctx, "bob", load)
+		if err != nil {
+			t.Fatalf("Swr failed while the circuit of a store was open: %v", err)
+		}
+
+		if value == nil || value.Name != "bob" {
+			t.Fatalf("got %v, want the loaded value", value)
+		}
+	}
+
+	if loads != 1 {
+		t.Fatalf("origin was called %d times, want the second Swr to be served from the healthy store", loads)
+	}
+
+	if err := ns.Set(ctx, "carol", user{Name: "carol"}, nil); err != nil {
+		t.Fatalf("Set with WriteAll failed because of a store with an open circuit: %v", err)
+	}
+}
+
+// readOnlyStore answers reads but fails every write
+type readOnlyStore struct {
+	*memory.MemoryStore
+}
+
+func (s readOnlyStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	return errors.New("read only")
+}
+
+func (s readOnlyStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	return errors.New("read only")
+}
+
+func TestSwrCacheWriteFails(t *testing.T) {
+	ctx := context.Background()
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{readOnlyStore{memory.New(memory.Config{})}},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+	})
+
+	value, err := ns.Swr(ctx, "alice", func(key string) (*user, error) {
+		return &user{Name: key}, nil
+	})
+	if err != nil || value == nil || value.Name != "alice" {
+		t.Fatalf("Swr got %v and %v, want the loaded value even though it could not be cached", value, err)
+	}
+
+	values, err := ns.SwrMany(ctx, []string{"alice", "bob"}, func(keys []string) ([]cache.GetMany[user], error) {
+		values := make([]cache.GetMany[user], 0, len(keys))
+		for _, key := range keys {
+			values = append(values, cache.GetMany[user]{Key: key, Value: &user{Name: key}, Found: true})
+		}
+		return values, nil
+	})
+	if err != nil || len(values) != 2 {
+		t.Fatalf("SwrMany got %v and %v, want the loaded values even though they could not be cached", values, err)
+	}
+}
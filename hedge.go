@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// ReadMode decides how Get asks the stores, GetMany always asks them one after another
+type ReadMode int
+
+const (
+	// Stores are asked one after another, this is the default
+	ReadSequential ReadMode = iota
+	// The next store is asked as soon as the current one missed, failed or didn't answer within HedgeDelay
+	ReadHedged
+	// All stores are asked at the same time
+	ReadRacing
+)
+
+func (m ReadMode) String() string {
+	switch m {
+	case ReadHedged:
+		return "hedged"
+	case ReadRacing:
+		return "racing"
+	default:
+		return "sequential"
+	}
+}
+
+type hedgeResult struct {
+	tier  tier
+	value types.TValue
+	found bool
+	err   error
+}
+
+// hedgedGet takes the first value any of the stores answers with and cancels the others through ctx
+func (t tieredCache[T]) hedgedGet(ctx context.Context, ns types.TNamespace, key string) (*types.TValue, bool, error) {
+	ctx, span := telemetry.NewSpan(ctx, "tiered.hedged-get")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+		telemetry.AttributeKV{Key: "read_mode", Value: t.readMode.String()},
+	)
+
+	tiers := make([]tier, 0, len(t.tiers))
+	for _, tier := range t.tiers {
+		if !tier.DisableReads {
+			tiers = append(tiers, tier)
+		}
+	}
+
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, len(tiers))
+	launch := func(tier tier) {
+		go func() {
+			ctx, span := telemetry.NewSpan(readCtx, tier.Store.Name()+".get")
+			defer span.End()
+			telemetry.WithAttributes(span,
+				telemetry.AttributeKV{Key: "key", Value: key},
+				telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+				telemetry.AttributeKV{Key: "tier", Value: tier.index},
+			)
+
+			var result T
+			start := time.Now()
+			value, found, err := tier.Store.Get(ctx, t.ns, key, &result)
+
+			// losing the race or an entry of an older shape of T is not the fault of the store
+			if err != nil && (readCtx.Err() != nil || errors.Is(err, ErrDecode)) {
+				tier.breaker.record(nil, time.Since(start))
+			} else {
+				tier.breaker.record(err, time.Since(start))
+			}
+			telemetry.RecordError(span, err)
+
+			undecodable := errors.Is(err, ErrDecode)
+			if undecodable || (err == nil && value.Value != nil && t.schema.mismatch(value)) {
+				var ok bool
+				value, ok = t.upgrade(context.WithoutCancel(ctx), ns, tier, key, undecodable)
+				found, err = ok, nil
+			}
+
+			results <- hedgeResult{tier: tier, value: value, found: found, err: err}
+		}()
+	}
+
+	delay := t.hedgeDelay
+	if t.readMode == ReadRacing {
+		delay = 0
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	next, pending, asked := 0, 0, 0
+	// asks the next store that is healthy, the breaker is only checked right before so a half open one is not blocked
+	launchNext := func() {
+		for next < len(tiers) {
+			tier := tiers[next]
+			next++
+
+			if tier.breaker.allow() {
+				launch(tier)
+				pending++
+				asked++
+				timer.Reset(delay)
+				return
+			}
+		}
+	}
+
+	var firstErr *hedgeResult
+
+	for {
+		if pending == 0 {
+			launchNext()
+
+			if pending == 0 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-timer.C:
+			launchNext()
+		case r := <-results:
+			pending--
+
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = &r
+				}
+			} else if r.value.Value != nil {
+				cancel()
+
+				telemetry.WithAttributes(span,
+					telemetry.AttributeKV{Key: "winner_store", Value: r.tier.Store.Name()},
+					telemetry.AttributeKV{Key: "winner_tier", Value: r.tier.index},
+					telemetry.AttributeKV{Key: "asked_stores", Value: asked},
+				)
+
+				if err := t.backfill(ctx, ns, r.tier.index, []types.TValue{r.value}); err != nil {
+					return nil, false, err
+				}
+
+				return &r.value, r.found, nil
+			}
+
+			// a miss or an error means there is no point in waiting for the delay
+			launchNext()
+		}
+	}
+
+	// Same as the sequential read, errors are only ignored once the circuit of the store opened
+	if firstErr != nil && !firstErr.tier.breaker.skips() {
+		telemetry.RecordError(span, firstErr.err)
+		return nil, false, tierError(firstErr.tier, ns, "get", []string{key}, firstErr.err)
+	}
+
+	return nil, false, nil
+}
@@ -0,0 +1,106 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+// hangingStore never answers a Get, it only returns once ctx is done and reports why
+type hangingStore struct {
+	*memory.MemoryStore
+	cancelled chan error
+}
+
+func (s hangingStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	<-ctx.Done()
+	s.cancelled <- ctx.Err()
+	return types.TValue{}, false, ctx.Err()
+}
+
+// askedStore reports when it is asked for a value
+type askedStore struct {
+	*memory.MemoryStore
+	asked chan time.Time
+}
+
+func (s askedStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	s.asked <- time.Now()
+	return s.MemoryStore.Get(ctx, ns, key, T)
+}
+
+func newHedgedNamespace(t *testing.T, mode cache.ReadMode) (cache.Namespace[user], hangingStore, askedStore) {
+	t.Helper()
+
+	slow := hangingStore{MemoryStore: memory.New(memory.Config{}), cancelled: make(chan error, 1)}
+	fast := askedStore{MemoryStore: memory.New(memory.Config{}), asked: make(chan time.Time, 1)}
+
+	err := fast.MemoryStore.Set(context.Background(), "user", "alice", types.TValue{
+		Key:        "alice",
+		Value:      user{Name: "alice"},
+		FreshUntil: time.Now().Add(time.Minute),
+		StaleUntil: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := cache.NewNamespace[user]("user", context.Background(), cache.NamespaceConfig{
+		Stores:     []cache.Store{slow, fast},
+		Fresh:      time.Minute,
+		Stale:      time.Hour,
+		ReadMode:   mode,
+		HedgeDelay: 50 * time.Millisecond,
+	})
+
+	return ns, slow, fast
+}
+
+func TestHedgedGet(t *testing.T) {
+	ns, slow, fast := newHedgedNamespace(t, cache.ReadHedged)
+
+	start := time.Now()
+	value, found, err := ns.Get(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found || value.Name != "alice" {
+		t.Fatalf("got %v, found %v, want alice from the second store", value, found)
+	}
+
+	if asked := (<-fast.asked).Sub(start); asked < 50*time.Millisecond {
+		t.Fatalf("second store was asked after %v, before the hedge delay of 50ms", asked)
+	}
+
+	select {
+	case err := <-slow.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("losing read ended with %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("losing read was not cancelled")
+	}
+}
+
+func TestRacingGet(t *testing.T) {
+	ns, slow, fast := newHedgedNamespace(t, cache.ReadRacing)
+
+	start := time.Now()
+	if _, found, err := ns.Get(context.Background(), "alice"); err != nil || !found {
+		t.Fatalf("got found %v and %v, want alice from the second store", found, err)
+	}
+
+	if asked := (<-fast.asked).Sub(start); asked >= 50*time.Millisecond {
+		t.Fatalf("second store was asked after %v, racing reads ask all stores at once", asked)
+	}
+
+	if err := <-slow.cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("losing read ended with %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// KeyedNamespace is a Namespace with keys of type K, which are turned into strings by a KeyEncoder
+type KeyedNamespace[K any, T any] struct {
+	namespace Namespace[T]
+	keys      KeyEncoder[K]
+}
+
+type KeyedGetMany[K any, T any] struct {
+	Key   K
+	Value *T
+	Found bool
+}
+
+type KeyedSetMany[K any, T any] struct {
+	Value T
+	Key   K
+	Opts  *types.SetOptions
+}
+
+// NewKeyedNamespace creates a Namespace that encodes its keys with keys, DefaultKey is used if keys is nil.
+// Loaders passed as options get the encoded keys, use Swr and SwrMany to load by K instead
+func NewKeyedNamespace[K any, T any](ns types.TNamespace, ctx context.Context, keys KeyEncoder[K], cfg NamespaceConfig, opts ...Option[T]) KeyedNamespace[K, T] {
+	if keys == nil {
+		keys = DefaultKey[K]()
+	}
+
+	return KeyedNamespace[K, T]{
+		namespace: NewNamespace[T](ns, ctx, cfg, opts...),
+		keys:      keys,
+	}
+}
+
+// Namespace returns the underlying namespace, which takes the encoded keys
+func (n KeyedNamespace[K, T]) Namespace() Namespace[T] {
+	return n.namespace
+}
+
+// EncodeKey returns the key that is used in the stores
+func (n KeyedNamespace[K, T]) EncodeKey(key K) (string, error) {
+	return n.keys.EncodeKey(key)
+}
+
+// encodeKeys returns the encoded keys in the same order and the key each of them was encoded from
+func (n KeyedNamespace[K, T]) encodeKeys(keys []K) ([]string, map[string]K, error) {
+	encoded := make([]string, len(keys))
+	lookup := make(map[string]K, len(keys))
+
+	for i, key := range keys {
+		k, err := n.keys.EncodeKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encoded[i] = k
+		lookup[k] = key
+	}
+
+	return encoded, lookup, nil
+}
+
+func (n KeyedNamespace[K, T]) Get(ctx context.Context, key K) (*T, bool, error) {
+	k, err := n.keys.EncodeKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return n.namespace.Get(ctx, k)
+}
+
+func (n KeyedNamespace[K, T]) Set(ctx context.Context, key K, value T, opts *types.SetOptions) error {
+	k, err := n.keys.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return n.namespace.Set(ctx, k, value, opts)
+}
+
+// GetMany returns the values in the order of keys
+func (n KeyedNamespace[K, T]) GetMany(ctx context.Context, keys []K) ([]KeyedGetMany[K, T], error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	encoded, _, err := n.encodeKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := n.namespace.GetMany(ctx, encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyedResults(keys, encoded, values), nil
+}
+
+func (n KeyedNamespace[K, T]) SetMany(ctx context.Context, values []KeyedSetMany[K, *T], opts *types.SetOptions) error {
+	if len(values) == 0 {
+		return ErrNoValues
+	}
+
+	encoded := make([]SetMany[*T], len(values))
+	for i, value := range values {
+		k, err := n.keys.EncodeKey(value.Key)
+		if err != nil {
+			return err
+		}
+
+		encoded[i] = SetMany[*T]{Key: k, Value: value.Value, Opts: value.Opts}
+	}
+
+	return n.namespace.SetMany(ctx, encoded, opts)
+}
+
+// Remove does nothing without keys, like Namespace.Remove
+func (n KeyedNamespace[K, T]) Remove(ctx context.Context, keys []K) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	encoded, _, err := n.encodeKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	return n.namespace.Remove(ctx, encoded)
+}
+
+func (n KeyedNamespace[K, T]) Swr(ctx context.Context, key K, refreshFromOrigin func(K) (*T, error)) (*T, error) {
+	k, err := n.keys.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.namespace.Swr(ctx, k, func(string) (*T, error) {
+		return refreshFromOrigin(key)
+	})
+}
+
+// SwrMany returns the values in the order of keys, refreshFromOrigin only gets the keys that have to be loaded
+func (n KeyedNamespace[K, T]) SwrMany(ctx context.Context, keys []K, refreshFromOrigin func([]K) ([]KeyedGetMany[K, T], error)) ([]KeyedGetMany[K, T], error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	encoded, lookup, err := n.encodeKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := n.namespace.SwrMany(ctx, encoded, func(missing []string) ([]GetMany[T], error) {
+		originKeys := make([]K, len(missing))
+		for i, k := range missing {
+			originKeys[i] = lookup[k]
+		}
+
+		loaded, err := refreshFromOrigin(originKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make([]GetMany[T], len(loaded))
+		for i, value := range loaded {
+			k, err := n.keys.EncodeKey(value.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			result[i] = GetMany[T]{Key: k, Value: value.Value, Found: value.Found}
+		}
+
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keyedResults(keys, encoded, values), nil
+}
+
+func (n KeyedNamespace[K, T]) Update(ctx context.Context, key K, fn func(old *T) (*T, error), opts *UpdateOptions) (*T, error) {
+	k, err := n.keys.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.namespace.Update(ctx, k, fn, opts)
+}
+
+// keyedResults puts values back into the order of keys, keys without a value are not found
+func keyedResults[K any, T any](keys []K, encoded []string, values []GetMany[T]) []KeyedGetMany[K, T] {
+	byKey := make(map[string]GetMany[T], len(values))
+	for _, value := range values {
+		byKey[value.Key] = value
+	}
+
+	result := make([]KeyedGetMany[K, T], len(keys))
+	for i, key := range keys {
+		value := byKey[encoded[i]]
+		result[i] = KeyedGetMany[K, T]{Key: key, Value: value.Value, Found: value.Found}
+	}
+
+	return result
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// KeyEncoder turns the keys of a KeyedNamespace into the strings that are passed to the stores.
+// Equal keys have to be encoded to the same string every time, also across restarts and instances.
+type KeyEncoder[K any] interface {
+	EncodeKey(key K) (string, error)
+}
+
+// KeyEncoderFunc allows using a plain function as KeyEncoder
+type KeyEncoderFunc[K any] func(key K) (string, error)
+
+func (f KeyEncoderFunc[K]) EncodeKey(key K) (string, error) {
+	return f(key)
+}
+
+// StringKey uses strings as they are
+func StringKey[K ~string]() KeyEncoder[K] {
+	return KeyEncoderFunc[K](func(key K) (string, error) {
+		return string(key), nil
+	})
+}
+
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// IntKey formats integers in base 10
+func IntKey[K integer]() KeyEncoder[K] {
+	return KeyEncoderFunc[K](func(key K) (string, error) {
+		if key < 0 {
+			return strconv.FormatInt(int64(key), 10), nil
+		}
+
+		return strconv.FormatUint(uint64(key), 10), nil
+	})
+}
+
+// JSONKey encodes keys as json, which works for structs, slices and maps.
+// Struct fields are written in the order they are declared and map keys are sorted, so the result is stable
+// as long as the type doesn't change.
+func JSONKey[K any]() KeyEncoder[K] {
+	return KeyEncoderFunc[K](func(key K) (string, error) {
+		b, err := json.Marshal(key)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidKey, err)
+		}
+
+		return string(b), nil
+	})
+}
+
+// CompositeKey joins the parts returned by parts with ":", e.g. a tenant and a user id become "tenant:42".
+// Colons and backslashes inside a part are escaped, so different parts can never result in the same key.
+func CompositeKey[K any](parts func(key K) []string) KeyEncoder[K] {
+	return KeyEncoderFunc[K](func(key K) (string, error) {
+		p := parts(key)
+		if len(p) == 0 {
+			return "", fmt.Errorf("%w: composite key has no parts", ErrInvalidKey)
+		}
+
+		var b strings.Builder
+		for i, part := range p {
+			if i > 0 {
+				b.WriteByte(':')
+			}
+
+			for _, r := range part {
+				if r == ':' || r == '\\' {
+					b.WriteByte('\\')
+				}
+				b.WriteRune(r)
+			}
+		}
+
+		return b.String(), nil
+	})
+}
+
+// HashedKey replaces keys of enc that are longer than maxLength with the hex encoded sha256 of the key.
+// The first part of the key is kept in front of the hash, so the keys are still readable in the store
+func HashedKey[K any](enc KeyEncoder[K], maxLength int) KeyEncoder[K] {
+	// a hash is 64 characters, plus a separator
+	if maxLength < 65 {
+		maxLength = 65
+	}
+
+	return KeyEncoderFunc[K](func(key K) (string, error) {
+		s, err := enc.EncodeKey(key)
+		if err != nil {
+			return "", err
+		}
+
+		if len(s) <= maxLength {
+			return s, nil
+		}
+
+		hash := sha256.Sum256([]byte(s))
+		return s[:maxLength-65] + "#" + hex.EncodeToString(hash[:]), nil
+	})
+}
+
+// DefaultKey is used by NewKeyedNamespace if no KeyEncoder is set. fmt.Stringer is formatted with String,
+// strings and integers are used as they are, also named ones like `type UserID string`.
+// Everything else is encoded with JSONKey
+func DefaultKey[K any]() KeyEncoder[K] {
+	jsonKey := JSONKey[K]()
+
+	return KeyEncoderFunc[K](func(key K) (string, error) {
+		if s, ok := any(key).(fmt.Stringer); ok {
+			return s.String(), nil
+		}
+
+		v := reflect.ValueOf(key)
+		switch v.Kind() {
+		case reflect.String:
+			return v.String(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(v.Int(), 10), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return strconv.FormatUint(v.Uint(), 10), nil
+		}
+
+		return jsonKey.EncodeKey(key)
+	})
+}
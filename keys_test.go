@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type userID string
+
+type shardID uint16
+
+type tenantKey struct {
+	Tenant string
+	ID     int
+}
+
+func TestDefaultKey(t *testing.T) {
+	check := func(got string, err error, want string) {
+		t.Helper()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	got, err := DefaultKey[string]().EncodeKey("alice")
+	check(got, err, "alice")
+
+	got, err = DefaultKey[userID]().EncodeKey("alice")
+	check(got, err, "alice")
+
+	got, err = DefaultKey[int]().EncodeKey(-42)
+	check(got, err, "-42")
+
+	got, err = DefaultKey[shardID]().EncodeKey(7)
+	check(got, err, "7")
+
+	got, err = DefaultKey[time.Duration]().EncodeKey(time.Second)
+	check(got, err, "1s")
+
+	got, err = DefaultKey[tenantKey]().EncodeKey(tenantKey{Tenant: "acme", ID: 1})
+	check(got, err, `{"Tenant":"acme","ID":1}`)
+}
+
+func TestKeyedRemoveNoKeys(t *testing.T) {
+	ns := NewKeyedNamespace[userID, string]("users", context.Background(), nil, NamespaceConfig{})
+
+	if err := ns.Remove(context.Background(), nil); err != nil {
+		t.Fatalf("Remove without keys got %v, want nil like Namespace.Remove", err)
+	}
+}
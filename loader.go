@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+)
+
+// Fetch works like Swr but uses the loader passed WithLoader,
+// so call sites don't have to pass the same refreshFromOrigin everywhere.
+func (n Namespace[T]) Fetch(ctx context.Context, key string) (*T, error) {
+	ctx, span := telemetry.NewSpan(ctx, "namespace.fetch")
+	defer span.End()
+
+	if n.loader == nil {
+		return nil, ErrNoLoader
+	}
+
+	// the load is shared with every other caller of the key, so it must not stop when this caller gives up.
+	// Each caller still stops waiting once its own ctx is done
+	loadCtx := context.WithoutCancel(ctx)
+
+	return n.Swr(ctx, key, func(key string) (*T, error) {
+		return n.loader(loadCtx, key)
+	})
+}
+
+// FetchMany works like SwrMany but uses the loader passed WithBatchLoader,
+// if only WithLoader was passed it is called for every key instead.
+func (n Namespace[T]) FetchMany(ctx context.Context, keys []string) ([]GetMany[T], error) {
+	ctx, span := telemetry.NewSpan(ctx, "namespace.fetch-many")
+	defer span.End()
+
+	if n.batchLoader == nil {
+		return nil, ErrNoLoader
+	}
+
+	// shared with other callers like in Fetch
+	loadCtx := context.WithoutCancel(ctx)
+
+	return n.SwrMany(ctx, keys, func(keys []string) ([]GetMany[T], error) {
+		return n.batchLoader(loadCtx, keys)
+	})
+}
+
+// batchFromLoader turns a single key loader into a batch loader that runs at most concurrency loads at once
+func batchFromLoader[T any](loader func(context.Context, string) (*T, error), concurrency int) func(context.Context, []string) ([]GetMany[T], error) {
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+
+	return func(ctx context.Context, keys []string) ([]GetMany[T], error) {
+		values := make([]GetMany[T], len(keys))
+		semaphore := make(chan struct{}, concurrency)
+
+		wg := sync.WaitGroup{}
+		mu := sync.Mutex{}
+		var firstErr error
+
+		for i, key := range keys {
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return nil, ctx.Err()
+			}
+
+			wg.Add(1)
+			go func(i int, key string) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				setErr := func(err error) {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+
+				// a panic in here would not reach the caller, so it is handed back as an error
+				defer func() {
+					if r := recover(); r != nil {
+						setErr(&PanicError{Value: r, Stack: debug.Stack()})
+					}
+				}()
+
+				value, err := loader(ctx, key)
+				if err != nil {
+					setErr(err)
+					return
+				}
+
+				values[i] = GetMany[T]{
+					Key:   key,
+					Value: value,
+					Found: value != nil,
+				}
+			}(i, key)
+		}
+
+		wg.Wait()
+
+		if firstErr != nil {
+			return nil, firstErr
+		}
+
+		return values, nil
+	}
+}
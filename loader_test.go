@@ -0,0 +1,69 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+type user struct {
+	Name string
+}
+
+func TestFetchCallerCancelled(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	loadErr := make(chan error, 1)
+
+	ns := cache.NewNamespace[user]("user", context.Background(), cache.NamespaceConfig{
+		Stores: []cache.Store{memory.New(memory.Config{})},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+	}, cache.WithLoader(func(ctx context.Context, key string) (*user, error) {
+		close(started)
+		<-release
+		loadErr <- ctx.Err()
+		return &user{Name: key}, nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := ns.Fetch(ctx, "alice")
+		first <- err
+	}()
+	<-started
+
+	second := make(chan *user, 1)
+	go func() {
+		value, _ := ns.Fetch(context.Background(), "alice")
+		second <- value
+	}()
+
+	// make sure the second caller joined the load before the first one gives up
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled caller got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled caller kept waiting for the load")
+	}
+
+	close(release)
+
+	if err := <-loadErr; err != nil {
+		t.Fatalf("loader ctx was done with %v after the caller that started it was cancelled", err)
+	}
+
+	if value := <-second; value == nil || value.Name != "alice" {
+		t.Fatalf("second caller got %v, want the loaded value", value)
+	}
+}
@@ -5,3 +5,42 @@ type StoreMiddleware interface {
 	// Wrap takes a Store and returns a new Store with added functionality
 	Wrap(Store) Store
 }
+
+// Unwrapper is implemented by the stores middlewares return, Unwrap returns the store they wrap
+type Unwrapper interface {
+	Unwrap() Store
+}
+
+// UnwrapStore returns the store at the bottom of all middlewares, which is store itself if it is not wrapped
+func UnwrapStore(store Store) Store {
+	for {
+		unwrapper, ok := store.(Unwrapper)
+		if !ok {
+			return store
+		}
+
+		store = unwrapper.Unwrap()
+	}
+}
+
+// Capability returns store as I if it and every store below it implement I. Middlewares implement the optional
+// interfaces whether or not the store they wrap does, so asserting the outermost store alone is not enough
+func Capability[I any](store Store) (I, bool) {
+	capable, ok := store.(I)
+	if !ok {
+		return capable, false
+	}
+
+	for {
+		unwrapper, ok := store.(Unwrapper)
+		if !ok {
+			return capable, true
+		}
+
+		store = unwrapper.Unwrap()
+		if _, ok := store.(I); !ok {
+			var none I
+			return none, false
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package encryption
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -11,6 +12,7 @@ import (
 	"log"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-json"
 	"github.com/steamsets/go-cache"
@@ -48,12 +50,26 @@ func (e EncryptedStore) Name() string {
 	return e.store.Name()
 }
 
+// Unwrap returns the store that holds the encrypted values
+func (e *EncryptedStore) Unwrap() cache.Store {
+	return e.store
+}
+
+func (e *EncryptedStore) Ping(ctx context.Context) error {
+	pinger, ok := e.store.(cache.Pinger)
+	if !ok {
+		return cache.NewStoreError(e.Name(), "", "ping", nil, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return pinger.Ping(ctx)
+}
+
 func (e *EncryptedStore) CreateCacheKey(namespace types.TNamespace, key string) string {
 	return strings.Join([]string{key, e.encryptionKeyHash}, "/")
 }
 
-func (e *EncryptedStore) Get(ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
-	val, found, err := e.store.Get(ns, e.CreateCacheKey(ns, key), &EncryptedValue{})
+func (e *EncryptedStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
+	val, found, err := e.store.Get(ctx, ns, e.CreateCacheKey(ns, key), &EncryptedValue{})
 	if err != nil {
 		return types.TValue{}, false, err
 	}
@@ -62,55 +78,168 @@ func (e *EncryptedStore) Get(ns types.TNamespace, key string, T any) (value type
 		return types.TValue{}, false, nil
 	}
 
-	asValue, ok := val.Value.(EncryptedValue)
-	if !ok {
-		return types.TValue{}, false, nil
+	return e.decryptValue(ns, "get", key, val, T)
+}
+
+// decryptValue replaces the encrypted value with the decrypted one, the key is set to the one that was asked for
+func (e *EncryptedStore) decryptValue(ns types.TNamespace, op string, key string, val types.TValue, T any) (types.TValue, bool, error) {
+	var asValue *EncryptedValue
+	switch v := val.Value.(type) {
+	case EncryptedValue:
+		asValue = &v
+	// stores that keep values as they are, like memory
+	case *EncryptedValue:
+		asValue = v
+	default:
+		return types.TValue{Key: key}, false, nil
 	}
 
-	decrypted, err := e.Decrypt(&asValue)
+	decrypted, err := e.Decrypt(asValue)
 	if err != nil {
-		return types.TValue{}, false, err
+		return types.TValue{}, false, cache.NewStoreError(e.Name(), ns, op, []string{key}, cache.ErrDecode, err)
 	}
 
 	localT := reflect.New(reflect.TypeOf(T).Elem()).Interface()
 	v, err := types.SetTIntoValue([]byte(decrypted), localT)
+	if err != nil {
+		return types.TValue{}, false, cache.NewStoreError(e.Name(), ns, op, []string{key}, cache.ErrDecode, err)
+	}
 
+	val.Key = key
+	val.Found = true
 	val.Value = v.Value
-	return val, true, err
+	return val, true, nil
 }
 
-func (e *EncryptedStore) GetMany(ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
-	return nil, nil
+func (e *EncryptedStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	keysToGet := make([]string, 0, len(keys))
+	for _, k := range keys {
+		keysToGet = append(keysToGet, e.CreateCacheKey(ns, k))
+	}
+
+	found, err := e.store.GetMany(ctx, ns, keysToGet, &EncryptedValue{})
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]types.TValue, len(found))
+	for _, val := range found {
+		byKey[val.Key] = val
+	}
+
+	values := make([]types.TValue, 0, len(keys))
+	for i, key := range keys {
+		val, ok := byKey[keysToGet[i]]
+		if !ok || !val.Found {
+			values = append(values, types.TValue{Found: false, Key: key})
+			continue
+		}
+
+		val, _, err := e.decryptValue(ns, "get-many", key, val, T)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, val)
+	}
+
+	return values, nil
 }
 
-func (e *EncryptedStore) Set(ns types.TNamespace, key string, value types.TValue) error {
-	cacheKey := e.CreateCacheKey(ns, key)
-	b, err := json.Marshal(value.Value)
+func (e *EncryptedStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	value, err := e.encryptValue(value)
 	if err != nil {
 		return err
 	}
 
-	encrypted, err := e.Encrypt(string(b))
+	return e.store.Set(ctx, ns, e.CreateCacheKey(ns, key), value)
+}
+
+func (e *EncryptedStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	store, ok := e.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(e.Name(), ns, "set-if-absent", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	value, err := e.encryptValue(value)
 	if err != nil {
 		return err
 	}
 
-	value.Value = encrypted
+	return store.SetIfAbsent(ctx, ns, e.CreateCacheKey(ns, key), value)
+}
+
+func (e *EncryptedStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	store, ok := e.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(e.Name(), ns, "compare-and-swap", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	value, err := e.encryptValue(value)
+	if err != nil {
+		return err
+	}
 
-	return e.store.Set(ns, cacheKey, value)
+	return store.CompareAndSwap(ctx, ns, e.CreateCacheKey(ns, key), expected, value)
 }
 
-func (e *EncryptedStore) SetMany(ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
-	return nil
+// Counters are plain numbers and are not encrypted, only their key is changed like the one of values
+func (e *EncryptedStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	store, ok := e.store.(cache.CounterStore)
+	if !ok {
+		return 0, cache.NewStoreError(e.Name(), ns, "increment", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return store.Increment(ctx, ns, e.CreateCacheKey(ns, key), delta, ttl)
+}
+
+func (e *EncryptedStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	store, ok := e.store.(cache.CounterStore)
+	if !ok {
+		return 0, false, cache.NewStoreError(e.Name(), ns, "counter", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return store.Counter(ctx, ns, e.CreateCacheKey(ns, key))
+}
+
+// encryptValue replaces the value with its encrypted json, the fresh and stale times and the revision stay readable
+func (e *EncryptedStore) encryptValue(value types.TValue) (types.TValue, error) {
+	b, err := json.Marshal(value.Value)
+	if err != nil {
+		return value, err
+	}
+
+	encrypted, err := e.Encrypt(string(b))
+	if err != nil {
+		return value, err
+	}
+
+	value.Value = encrypted
+	return value, nil
+}
+
+func (e *EncryptedStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	encrypted := make([]types.TValue, 0, len(values))
+	for _, value := range values {
+		value, err := e.encryptValue(value)
+		if err != nil {
+			return err
+		}
+
+		value.Key = e.CreateCacheKey(ns, value.Key)
+		encrypted = append(encrypted, value)
+	}
+
+	return e.store.SetMany(ctx, ns, encrypted, opts)
 }
 
-func (e *EncryptedStore) Remove(ns types.TNamespace, key []string) error {
+func (e *EncryptedStore) Remove(ctx context.Context, ns types.TNamespace, key []string) error {
 	keysToRemove := make([]string, 0)
 	for _, k := range key {
 		keysToRemove = append(keysToRemove, e.CreateCacheKey(ns, k))
 	}
 
-	return e.store.Remove(ns, keysToRemove)
+	return e.store.Remove(ctx, ns, keysToRemove)
 }
 
 func encode(data []byte) string {
@@ -0,0 +1,59 @@
+package encryption_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/middleware/encryption"
+	"github.com/steamsets/go-cache/store/memory"
+	"github.com/steamsets/go-cache/storetest"
+)
+
+// 32 zero bytes, only for tests
+const testKey = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func TestGetMany(t *testing.T) {
+	storetest.RunGetMany(t, func(t *testing.T) cache.Store {
+		return encryption.FromBase64Key(testKey).Wrap(memory.New(memory.Config{}))
+	})
+}
+
+func TestPassesOptionalInterfaces(t *testing.T) {
+	ctx := context.Background()
+	store := encryption.FromBase64Key(testKey).Wrap(memory.New(memory.Config{}))
+
+	pinger, ok := store.(cache.Pinger)
+	if !ok {
+		t.Fatal("encrypted store does not implement cache.Pinger")
+	}
+
+	// memory has no Ping, which the namespace has to be able to tell through the middleware
+	if err := pinger.Ping(ctx); !errors.Is(err, cache.ErrUnsupported) {
+		t.Fatalf("Ping of a store without one got %v, want ErrUnsupported", err)
+	}
+
+	if _, ok := cache.Capability[cache.Pinger](store); ok {
+		t.Fatal("encrypted memory store is a Pinger")
+	}
+
+	counters, ok := store.(cache.CounterStore)
+	if !ok {
+		t.Fatal("encrypted store does not implement cache.CounterStore")
+	}
+
+	if _, err := counters.Increment(ctx, "ns", "views", 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := counters.Counter(ctx, "ns", "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found || value != 2 {
+		t.Fatalf("got %d, found %v, want 2", value, found)
+	}
+}
@@ -0,0 +1,350 @@
+package hashkey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// Limits describe which keys a store accepts, they are checked against the key created by the store's CreateCacheKey
+type Limits struct {
+	// Longest key in bytes, 0 means no limit
+	MaxLength int
+	// Characters that can't be part of a key
+	Invalid func(r rune) bool
+	// Keys that can't be used at all
+	Reserved []string
+}
+
+var (
+	// memcached allows 250 bytes without spaces or control characters
+	Memcached = Limits{
+		MaxLength: 250,
+		Invalid: func(r rune) bool {
+			return r <= ' ' || r == 0x7f
+		},
+	}
+	// Cloudflare KV allows 512 bytes, except for "." and ".."
+	CloudflareKV = Limits{
+		MaxLength: 512,
+		Reserved:  []string{".", ".."},
+	}
+)
+
+func (l Limits) allows(key string) bool {
+	if l.MaxLength > 0 && len(key) > l.MaxLength {
+		return false
+	}
+
+	if l.Invalid != nil && strings.IndexFunc(key, l.Invalid) >= 0 {
+		return false
+	}
+
+	for _, reserved := range l.Reserved {
+		if key == reserved {
+			return false
+		}
+	}
+
+	return true
+}
+
+// prefix of hashed keys, keys that already start with it are hashed as well so they can't be mistaken for one
+const prefix = "#sha256:"
+
+// Envelope is written instead of the value of a hashed key. It keeps the original key, so a value that was written
+// by another key with the same hash is treated as a miss instead of being returned
+type Envelope struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// rawEnvelope is what remote stores decode an Envelope into, the value is decoded once T is known
+type rawEnvelope struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// HashKeyStore wraps another store and replaces keys the store does not accept with their sha256.
+// Only the key is changed, namespaces are passed on as they are and have to be valid for the store.
+type HashKeyStore struct {
+	store  cache.Store
+	limits Limits
+}
+
+type HashKeyStoreMiddleware struct {
+	limits Limits
+}
+
+func New(limits Limits) cache.StoreMiddleware {
+	return &HashKeyStoreMiddleware{
+		limits: limits,
+	}
+}
+
+func (m *HashKeyStoreMiddleware) Wrap(store cache.Store) cache.Store {
+	return &HashKeyStore{
+		store:  store,
+		limits: m.limits,
+	}
+}
+
+func (h *HashKeyStore) Name() string {
+	return h.store.Name()
+}
+
+// Unwrap returns the store that gets the hashed keys
+func (h *HashKeyStore) Unwrap() cache.Store {
+	return h.store
+}
+
+func (h *HashKeyStore) CreateCacheKey(namespace types.TNamespace, key string) string {
+	k, _ := h.key(namespace, key)
+	return h.store.CreateCacheKey(namespace, k)
+}
+
+func (h *HashKeyStore) Ping(ctx context.Context) error {
+	pinger, ok := h.store.(cache.Pinger)
+	if !ok {
+		return cache.NewStoreError(h.Name(), "", "ping", nil, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return pinger.Ping(ctx)
+}
+
+// key returns the key to pass to the wrapped store and whether it was hashed
+func (h *HashKeyStore) key(ns types.TNamespace, key string) (string, bool) {
+	if !strings.HasPrefix(key, prefix) && h.limits.allows(h.store.CreateCacheKey(ns, key)) {
+		return key, false
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	return prefix + hex.EncodeToString(hash[:]), true
+}
+
+func (h *HashKeyStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	k, hashed := h.key(ns, key)
+	if !hashed {
+		return h.store.Get(ctx, ns, k, T)
+	}
+
+	value, found, err := h.store.Get(ctx, ns, k, &rawEnvelope{})
+	if err != nil || !found {
+		return value, found, logicalError(err, map[string]string{k: key})
+	}
+
+	return h.unwrap(ns, key, value, T)
+}
+
+// unwrap replaces the envelope with the value it holds, envelopes of another key are a miss
+func (h *HashKeyStore) unwrap(ns types.TNamespace, key string, value types.TValue, T any) (types.TValue, bool, error) {
+	value.Key = key
+
+	switch envelope := value.Value.(type) {
+	// stores that keep values as they are, like memory
+	case Envelope:
+		if envelope.Key != key {
+			return types.TValue{Key: key}, false, nil
+		}
+
+		value.Value = envelope.Value
+	case rawEnvelope:
+		if envelope.Key != key {
+			return types.TValue{Key: key}, false, nil
+		}
+
+		if T == nil {
+			value.Value = envelope.Value
+			break
+		}
+
+		localT := reflect.New(reflect.TypeOf(T).Elem()).Interface()
+		if err := json.Unmarshal(envelope.Value, localT); err != nil {
+			return types.TValue{}, false, cache.NewStoreError(h.Name(), ns, "get", []string{key}, cache.ErrDecode, err)
+		}
+
+		value.Value = reflect.ValueOf(localT).Elem().Interface()
+	default:
+		// written without this middleware, nothing tells which key it belongs to
+		return types.TValue{Key: key}, false, nil
+	}
+
+	return value, true, nil
+}
+
+func (h *HashKeyStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	plain := make([]string, 0, len(keys))
+	hashedKeys := make([]string, 0)
+	// hashed key to the key that was asked for
+	logical := make(map[string]string)
+
+	for _, key := range keys {
+		k, hashed := h.key(ns, key)
+		if !hashed {
+			plain = append(plain, key)
+			continue
+		}
+
+		hashedKeys = append(hashedKeys, k)
+		logical[k] = key
+	}
+
+	byKey := make(map[string]types.TValue, len(keys))
+
+	if len(plain) > 0 {
+		found, err := h.store.GetMany(ctx, ns, plain, T)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range found {
+			byKey[value.Key] = value
+		}
+	}
+
+	if len(hashedKeys) > 0 {
+		found, err := h.store.GetMany(ctx, ns, hashedKeys, &rawEnvelope{})
+		if err != nil {
+			return nil, logicalError(err, logical)
+		}
+
+		for _, value := range found {
+			key, ok := logical[value.Key]
+			if !ok || !value.Found {
+				continue
+			}
+
+			value, ok, err := h.unwrap(ns, key, value, T)
+			if err != nil {
+				return nil, err
+			}
+
+			value.Found = ok
+			byKey[key] = value
+		}
+	}
+
+	values := make([]types.TValue, 0, len(keys))
+	for _, key := range keys {
+		value, ok := byKey[key]
+		if !ok {
+			value = types.TValue{Found: false, Key: key}
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// wrap puts the value into an Envelope if its key has to be hashed, the value keeps the key it is stored under
+func (h *HashKeyStore) wrap(ns types.TNamespace, key string, value types.TValue) (string, types.TValue) {
+	k, hashed := h.key(ns, key)
+	if hashed {
+		value.Key = k
+		value.Value = Envelope{Key: key, Value: value.Value}
+	}
+
+	return k, value
+}
+
+func (h *HashKeyStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	k, value := h.wrap(ns, key, value)
+	return logicalError(h.store.Set(ctx, ns, k, value), map[string]string{k: key})
+}
+
+func (h *HashKeyStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	wrapped := make([]types.TValue, len(values))
+	logical := make(map[string]string, len(values))
+	for i, value := range values {
+		var k string
+		k, wrapped[i] = h.wrap(ns, value.Key, value)
+		logical[k] = value.Key
+	}
+
+	return logicalError(h.store.SetMany(ctx, ns, wrapped, opts), logical)
+}
+
+func (h *HashKeyStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	store, ok := h.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(h.Name(), ns, "set-if-absent", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	k, value := h.wrap(ns, key, value)
+	return logicalError(store.SetIfAbsent(ctx, ns, k, value), map[string]string{k: key})
+}
+
+func (h *HashKeyStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	store, ok := h.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(h.Name(), ns, "compare-and-swap", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	k, value := h.wrap(ns, key, value)
+	return logicalError(store.CompareAndSwap(ctx, ns, k, expected, value), map[string]string{k: key})
+}
+
+// Counters are plain numbers, only their key is hashed
+func (h *HashKeyStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	store, ok := h.store.(cache.CounterStore)
+	if !ok {
+		return 0, cache.NewStoreError(h.Name(), ns, "increment", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	k, _ := h.key(ns, key)
+	value, err := store.Increment(ctx, ns, k, delta, ttl)
+	return value, logicalError(err, map[string]string{k: key})
+}
+
+func (h *HashKeyStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	store, ok := h.store.(cache.CounterStore)
+	if !ok {
+		return 0, false, cache.NewStoreError(h.Name(), ns, "counter", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	k, _ := h.key(ns, key)
+	value, found, err := store.Counter(ctx, ns, k)
+	return value, found, logicalError(err, map[string]string{k: key})
+}
+
+func (h *HashKeyStore) Remove(ctx context.Context, ns types.TNamespace, keys []string) error {
+	hashed := make([]string, len(keys))
+	logical := make(map[string]string, len(keys))
+	for i, key := range keys {
+		hashed[i], _ = h.key(ns, key)
+		logical[hashed[i]] = key
+	}
+
+	return logicalError(h.store.Remove(ctx, ns, hashed), logical)
+}
+
+// logicalError replaces the keys of a StoreError with the keys that were asked for, logical maps the keys
+// that were passed to the wrapped store to them. Callers use the keys of the error, e.g. to leave out the
+// entries that could not be decoded
+func logicalError(err error, logical map[string]string) error {
+	var storeErr *cache.StoreError
+	if !errors.As(err, &storeErr) {
+		return err
+	}
+
+	e := *storeErr
+	e.Keys = make([]string, len(storeErr.Keys))
+	for i, key := range storeErr.Keys {
+		if l, ok := logical[key]; ok {
+			key = l
+		}
+
+		e.Keys[i] = key
+	}
+
+	return &e
+}
@@ -0,0 +1,57 @@
+package hashkey_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/middleware/hashkey"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+	"github.com/steamsets/go-cache/storetest"
+)
+
+// undecodableStore fails every GetMany for all keys it was asked for
+type undecodableStore struct {
+	*memory.MemoryStore
+}
+
+func (s undecodableStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	return nil, cache.NewStoreError("undecodable", ns, "get-many", keys, cache.ErrDecode, errors.New("invalid json"))
+}
+
+// plainKeyStore checks the limits against the key alone, the namespaces of storetest differ in length
+type plainKeyStore struct {
+	*memory.MemoryStore
+}
+
+func (s plainKeyStore) CreateCacheKey(ns types.TNamespace, key string) string {
+	return key
+}
+
+func TestGetMany(t *testing.T) {
+	// "key-1" is used as it is while "key-10" is hashed, so GetMany gets both kinds of keys
+	limits := hashkey.Limits{MaxLength: 5}
+
+	storetest.RunGetMany(t, func(t *testing.T) cache.Store {
+		return hashkey.New(limits).Wrap(plainKeyStore{memory.New(memory.Config{})})
+	})
+}
+
+func TestGetManyErrorKeys(t *testing.T) {
+	store := hashkey.New(hashkey.Limits{MaxLength: 20}).Wrap(undecodableStore{memory.New(memory.Config{})})
+	long := strings.Repeat("k", 30)
+
+	_, err := store.GetMany(context.Background(), "ns", []string{long}, nil)
+
+	var storeErr *cache.StoreError
+	if !errors.As(err, &storeErr) || !errors.Is(err, cache.ErrDecode) {
+		t.Fatalf("got %v, want a StoreError with ErrDecode", err)
+	}
+
+	if len(storeErr.Keys) != 1 || storeErr.Keys[0] != long {
+		t.Fatalf("error has keys %v, want the key that was asked for", storeErr.Keys)
+	}
+}
@@ -3,9 +3,27 @@ package middleware
 import (
 	"github.com/steamsets/go-cache"
 	"github.com/steamsets/go-cache/middleware/encryption"
+	"github.com/steamsets/go-cache/middleware/hashkey"
+	"github.com/steamsets/go-cache/middleware/retry"
+	"github.com/steamsets/go-cache/middleware/timeout"
 )
 
 // use openssl rand -base64 32 to generate a random key
 func WithEncryption(key string) cache.StoreMiddleware {
 	return encryption.FromBase64Key(key)
 }
+
+// retries failed store calls, e.g. retry.Policy{Retryable: redis.IsRetryable}
+func WithRetry(policy retry.Policy) cache.StoreMiddleware {
+	return retry.New(policy)
+}
+
+// gives up on store calls that take longer than configured, wrap it inside WithRetry to retry timeouts
+func WithTimeout(cfg timeout.Config) cache.StoreMiddleware {
+	return timeout.New(cfg)
+}
+
+// hashes keys the store does not accept, e.g. WithHashedKeys(hashkey.Memcached)
+func WithHashedKeys(limits hashkey.Limits) cache.StoreMiddleware {
+	return hashkey.New(limits)
+}
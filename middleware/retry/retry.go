@@ -0,0 +1,234 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+type Policy struct {
+	// Attempts including the first call, defaults to 3
+	MaxAttempts int
+	// Delay before the first retry, it is doubled for every further one. Defaults to 50ms
+	BaseDelay time.Duration
+	// Upper bound of the delay, defaults to 1s
+	MaxDelay time.Duration
+	// Share of the delay that is randomized so clients don't retry in lockstep, between 0 and 1. Defaults to 0.2
+	Jitter float64
+	// Decides whether an error is transient, e.g. redis.IsRetryable. Defaults to retrying every error
+	// that is not caused by the context or cache.ErrDecode
+	Retryable func(error) bool
+	// Whether Set, SetMany and Remove can safely be called again, they are only retried if so.
+	// SetIfAbsent, CompareAndSwap and Increment are never retried
+	IdempotentWrites bool
+}
+
+// RetryStore wraps another store and retries failed calls with exponential backoff,
+// retries never outlive the deadline of the context that was passed in.
+type RetryStore struct {
+	store  cache.Store
+	policy Policy
+}
+
+type RetryStoreMiddleware struct {
+	policy Policy
+}
+
+func New(policy Policy) cache.StoreMiddleware {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 3
+	}
+
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = 50 * time.Millisecond
+	}
+
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = time.Second
+	}
+
+	if policy.Jitter <= 0 || policy.Jitter > 1 {
+		policy.Jitter = 0.2
+	}
+
+	if policy.Retryable == nil {
+		policy.Retryable = func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, cache.ErrDecode)
+		}
+	}
+
+	return &RetryStoreMiddleware{
+		policy: policy,
+	}
+}
+
+func (m *RetryStoreMiddleware) Wrap(store cache.Store) cache.Store {
+	return &RetryStore{
+		store:  store,
+		policy: m.policy,
+	}
+}
+
+func (r *RetryStore) Name() string {
+	return r.store.Name()
+}
+
+// Unwrap returns the store whose calls are retried
+func (r *RetryStore) Unwrap() cache.Store {
+	return r.store
+}
+
+func (r *RetryStore) CreateCacheKey(namespace types.TNamespace, key string) string {
+	return r.store.CreateCacheKey(namespace, key)
+}
+
+func (r *RetryStore) Ping(ctx context.Context) error {
+	pinger, ok := r.store.(cache.Pinger)
+	if !ok {
+		return cache.NewStoreError(r.Name(), "", "ping", nil, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return pinger.Ping(ctx)
+}
+
+func (r *RetryStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
+	err = r.do(ctx, "get", true, func(ctx context.Context) error {
+		value, found, err = r.store.Get(ctx, ns, key, T)
+		return err
+	})
+
+	return value, found, err
+}
+
+func (r *RetryStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) (values []types.TValue, err error) {
+	err = r.do(ctx, "get-many", true, func(ctx context.Context) error {
+		values, err = r.store.GetMany(ctx, ns, keys, T)
+		return err
+	})
+
+	return values, err
+}
+
+func (r *RetryStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	return r.do(ctx, "set", r.policy.IdempotentWrites, func(ctx context.Context) error {
+		return r.store.Set(ctx, ns, key, value)
+	})
+}
+
+func (r *RetryStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	return r.do(ctx, "set-many", r.policy.IdempotentWrites, func(ctx context.Context) error {
+		return r.store.SetMany(ctx, ns, values, opts)
+	})
+}
+
+func (r *RetryStore) Remove(ctx context.Context, ns types.TNamespace, keys []string) error {
+	return r.do(ctx, "remove", r.policy.IdempotentWrites, func(ctx context.Context) error {
+		return r.store.Remove(ctx, ns, keys)
+	})
+}
+
+// SetIfAbsent is never retried, not even with IdempotentWrites. A retry after the write went through
+// would report a conflict for the caller's own write
+func (r *RetryStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	store, ok := r.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(r.store.Name(), ns, "set-if-absent", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return store.SetIfAbsent(ctx, ns, key, value)
+}
+
+// CompareAndSwap is never retried for the same reason as SetIfAbsent, the revision already changed once it went through
+func (r *RetryStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	store, ok := r.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(r.store.Name(), ns, "compare-and-swap", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return store.CompareAndSwap(ctx, ns, key, expected, value)
+}
+
+// Increment is never retried, a retry after the increment went through would count twice
+func (r *RetryStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	store, ok := r.store.(cache.CounterStore)
+	if !ok {
+		return 0, cache.NewStoreError(r.store.Name(), ns, "increment", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return store.Increment(ctx, ns, key, delta, ttl)
+}
+
+func (r *RetryStore) Counter(ctx context.Context, ns types.TNamespace, key string) (value int64, found bool, err error) {
+	store, ok := r.store.(cache.CounterStore)
+	if !ok {
+		return 0, false, cache.NewStoreError(r.store.Name(), ns, "counter", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	err = r.do(ctx, "counter", true, func(ctx context.Context) error {
+		value, found, err = store.Counter(ctx, ns, key)
+		return err
+	})
+
+	return value, found, err
+}
+
+func (r *RetryStore) do(ctx context.Context, operation string, retryable bool, fn func(context.Context) error) error {
+	err := fn(ctx)
+	if err == nil || !retryable {
+		return err
+	}
+
+	ctx, span := telemetry.NewSpan(ctx, r.store.Name()+".retry-"+operation)
+	defer span.End()
+
+	for attempt := 1; attempt < r.policy.MaxAttempts; attempt++ {
+		// a conflict is an answer, not a failure
+		if !r.policy.Retryable(err) || errors.Is(err, cache.ErrConflict) {
+			break
+		}
+
+		delay := r.delay(attempt)
+
+		// no point in waiting if the caller is gone before the next attempt
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			break
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			telemetry.RecordError(span, err)
+			return err
+		case <-timer.C:
+		}
+
+		telemetry.WithAttributes(span,
+			telemetry.AttributeKV{Key: "attempt", Value: attempt + 1},
+		)
+
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+	}
+
+	telemetry.RecordError(span, err)
+	return err
+}
+
+func (r *RetryStore) delay(attempt int) time.Duration {
+	delay := r.policy.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > r.policy.MaxDelay {
+		delay = r.policy.MaxDelay
+	}
+
+	jitter := time.Duration(float64(delay) * r.policy.Jitter * (rand.Float64()*2 - 1))
+
+	return delay + jitter
+}
@@ -0,0 +1,76 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache/middleware/retry"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+// flakyStore fails every write with a timeout after it reached the store, like a reply that got lost
+type flakyStore struct {
+	*memory.MemoryStore
+	calls int
+}
+
+var errLostReply = errors.New("i/o timeout")
+
+func (s *flakyStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	s.calls++
+	s.MemoryStore.Set(ctx, ns, key, value)
+	return errLostReply
+}
+
+func (s *flakyStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	s.calls++
+	s.MemoryStore.SetIfAbsent(ctx, ns, key, value)
+	return errLostReply
+}
+
+func (s *flakyStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	s.calls++
+	s.MemoryStore.CompareAndSwap(ctx, ns, key, expected, value)
+	return errLostReply
+}
+
+func TestConditionalWritesNotRetried(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{MemoryStore: memory.New(memory.Config{})}
+	store := retry.New(retry.Policy{
+		MaxAttempts:      3,
+		BaseDelay:        time.Millisecond,
+		IdempotentWrites: true,
+	}).Wrap(inner).(*retry.RetryStore)
+
+	value := types.TValue{Value: "value", FreshUntil: time.Now().Add(time.Minute), StaleUntil: time.Now().Add(time.Hour), Revision: 1}
+
+	if err := store.SetIfAbsent(ctx, "ns", "key", value); !errors.Is(err, errLostReply) {
+		t.Fatalf("SetIfAbsent got %v, want the error of the store", err)
+	}
+
+	if inner.calls != 1 {
+		t.Fatalf("SetIfAbsent was called %d times, want 1", inner.calls)
+	}
+
+	inner.calls = 0
+	value.Revision = 2
+	if err := store.CompareAndSwap(ctx, "ns", "key", 1, value); !errors.Is(err, errLostReply) {
+		t.Fatalf("CompareAndSwap got %v, want the error of the store", err)
+	}
+
+	if inner.calls != 1 {
+		t.Fatalf("CompareAndSwap was called %d times, want 1", inner.calls)
+	}
+
+	// plain writes are still retried with IdempotentWrites
+	inner.calls = 0
+	store.Set(ctx, "ns", "key", value)
+
+	if inner.calls != 3 {
+		t.Fatalf("Set was called %d times, want 3", inner.calls)
+	}
+}
@@ -0,0 +1,289 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+type Config struct {
+	// Timeout of Get, 0 means no timeout
+	Read time.Duration
+	// Timeout of Set and Remove of a single key, 0 means no timeout
+	Write time.Duration
+	// Timeout of GetMany, SetMany and Remove of more than one key, 0 falls back to Read or Write
+	Batch time.Duration
+	// Get and GetMany return a miss instead of an error when they time out, so Swr loads from the origin
+	ReadTimeoutAsMiss bool
+}
+
+// Error is returned when a store did not answer in time
+type Error struct {
+	Op      string
+	Store   string
+	Timeout time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s timed out after %s", e.Store, e.Op, e.Timeout)
+}
+
+// Is makes errors.Is(err, cache.ErrTimeout) work for timeouts of the middleware as well
+func (e *Error) Is(target error) bool {
+	return target == cache.ErrTimeout
+}
+
+// TimeoutStore wraps another store and gives up on calls that take longer than configured.
+// Calls run in their own goroutine, so stores that ignore the context can't block the caller either.
+type TimeoutStore struct {
+	store cache.Store
+	cfg   Config
+}
+
+type TimeoutStoreMiddleware struct {
+	cfg Config
+}
+
+func New(cfg Config) cache.StoreMiddleware {
+	return &TimeoutStoreMiddleware{
+		cfg: cfg,
+	}
+}
+
+func (m *TimeoutStoreMiddleware) Wrap(store cache.Store) cache.Store {
+	return &TimeoutStore{
+		store: store,
+		cfg:   m.cfg,
+	}
+}
+
+func (t *TimeoutStore) Name() string {
+	return t.store.Name()
+}
+
+// Unwrap returns the store whose calls are timed out
+func (t *TimeoutStore) Unwrap() cache.Store {
+	return t.store
+}
+
+func (t *TimeoutStore) CreateCacheKey(namespace types.TNamespace, key string) string {
+	return t.store.CreateCacheKey(namespace, key)
+}
+
+func (t *TimeoutStore) Ping(ctx context.Context) error {
+	pinger, ok := t.store.(cache.Pinger)
+	if !ok {
+		return cache.NewStoreError(t.Name(), "", "ping", nil, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return pinger.Ping(ctx)
+}
+
+func (t *TimeoutStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	type result struct {
+		value types.TValue
+		found bool
+	}
+
+	r, err := run(ctx, t, ns, "get", []string{key}, t.cfg.Read, func(ctx context.Context) (result, error) {
+		value, found, err := t.store.Get(ctx, ns, key, T)
+		return result{value: value, found: found}, err
+	})
+	if err != nil {
+		if errors.Is(err, cache.ErrTimeout) && t.cfg.ReadTimeoutAsMiss {
+			return types.TValue{}, false, nil
+		}
+
+		return types.TValue{}, false, err
+	}
+
+	return r.value, r.found, nil
+}
+
+func (t *TimeoutStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	values, err := run(ctx, t, ns, "get-many", keys, t.batch(t.cfg.Read), func(ctx context.Context) ([]types.TValue, error) {
+		return t.store.GetMany(ctx, ns, keys, T)
+	})
+	if err != nil {
+		if errors.Is(err, cache.ErrTimeout) && t.cfg.ReadTimeoutAsMiss {
+			values := make([]types.TValue, 0, len(keys))
+			for _, key := range keys {
+				values = append(values, types.TValue{Key: key, Found: false})
+			}
+
+			return values, nil
+		}
+
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func (t *TimeoutStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	_, err := run(ctx, t, ns, "set", []string{key}, t.cfg.Write, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.store.Set(ctx, ns, key, value)
+	})
+
+	return err
+}
+
+func (t *TimeoutStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	_, err := run(ctx, t, ns, "set-many", keysOf(values), t.batch(t.cfg.Write), func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.store.SetMany(ctx, ns, values, opts)
+	})
+
+	return err
+}
+
+func (t *TimeoutStore) Remove(ctx context.Context, ns types.TNamespace, keys []string) error {
+	timeout := t.cfg.Write
+	if len(keys) > 1 {
+		timeout = t.batch(t.cfg.Write)
+	}
+
+	_, err := run(ctx, t, ns, "remove", keys, timeout, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.store.Remove(ctx, ns, keys)
+	})
+
+	return err
+}
+
+func (t *TimeoutStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	store, ok := t.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(t.store.Name(), ns, "set-if-absent", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	_, err := run(ctx, t, ns, "set-if-absent", []string{key}, t.cfg.Write, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, store.SetIfAbsent(ctx, ns, key, value)
+	})
+
+	return err
+}
+
+func (t *TimeoutStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	store, ok := t.store.(cache.ConditionalStore)
+	if !ok {
+		return cache.NewStoreError(t.store.Name(), ns, "compare-and-swap", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	_, err := run(ctx, t, ns, "compare-and-swap", []string{key}, t.cfg.Write, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, store.CompareAndSwap(ctx, ns, key, expected, value)
+	})
+
+	return err
+}
+
+func (t *TimeoutStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	store, ok := t.store.(cache.CounterStore)
+	if !ok {
+		return 0, cache.NewStoreError(t.store.Name(), ns, "increment", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	return run(ctx, t, ns, "increment", []string{key}, t.cfg.Write, func(ctx context.Context) (int64, error) {
+		return store.Increment(ctx, ns, key, delta, ttl)
+	})
+}
+
+// Counter is a read, but a timeout is never turned into a miss as 0 would be a valid count
+func (t *TimeoutStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	store, ok := t.store.(cache.CounterStore)
+	if !ok {
+		return 0, false, cache.NewStoreError(t.store.Name(), ns, "counter", []string{key}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	type result struct {
+		value int64
+		found bool
+	}
+
+	r, err := run(ctx, t, ns, "counter", []string{key}, t.cfg.Read, func(ctx context.Context) (result, error) {
+		value, found, err := store.Counter(ctx, ns, key)
+		return result{value: value, found: found}, err
+	})
+
+	return r.value, r.found, err
+}
+
+func (t *TimeoutStore) batch(fallback time.Duration) time.Duration {
+	if t.cfg.Batch > 0 {
+		return t.cfg.Batch
+	}
+
+	return fallback
+}
+
+// run calls fn with a context that ends after timeout and stops waiting for it once it does,
+// an abandoned call keeps running in the background until the store returns.
+func run[V any](ctx context.Context, t *TimeoutStore, ns types.TNamespace, operation string, keys []string, timeout time.Duration, fn func(context.Context) (V, error)) (V, error) {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+
+	type result struct {
+		value V
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		defer cancel()
+		value, err := fn(callCtx)
+		done <- result{value: value, err: err}
+	}()
+
+	var zero V
+
+	select {
+	case r := <-done:
+		// the store may notice the deadline itself and return the context error
+		if r.err != nil && ctx.Err() == nil && callCtx.Err() == context.DeadlineExceeded {
+			return zero, t.timedOut(ctx, ns, operation, keys, timeout)
+		}
+
+		return r.value, r.err
+	case <-callCtx.Done():
+		// the caller gave up, that is not the store's fault
+		if ctx.Err() != nil {
+			return zero, ctx.Err()
+		}
+
+		return zero, t.timedOut(ctx, ns, operation, keys, timeout)
+	}
+}
+
+// timedOut wraps the Error into a cache.StoreError, so it carries the namespace and keys like errors of the stores do
+func (t *TimeoutStore) timedOut(ctx context.Context, ns types.TNamespace, operation string, keys []string, timeout time.Duration) error {
+	err := &Error{
+		Op:      operation,
+		Store:   t.store.Name(),
+		Timeout: timeout,
+	}
+
+	_, span := telemetry.NewSpan(ctx, t.store.Name()+".timeout")
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "operation", Value: operation},
+		telemetry.AttributeKV{Key: "timeout", Value: timeout.String()},
+	)
+	telemetry.RecordError(span, err)
+	span.End()
+
+	return cache.NewStoreError(t.store.Name(), ns, operation, keys, nil, err)
+}
+
+func keysOf(values []types.TValue) []string {
+	keys := make([]string, 0, len(values))
+	for _, v := range values {
+		keys = append(keys, v.Key)
+	}
+
+	return keys
+}
@@ -0,0 +1,125 @@
+package timeout_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/middleware/timeout"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+type ctxKey struct{}
+
+// stuckStore only answers Get once ctx is done and reports the ctx it got, GetMany ignores ctx and waits for release
+type stuckStore struct {
+	*memory.MemoryStore
+	got     chan context.Context
+	release chan struct{}
+}
+
+func newStuckStore() *stuckStore {
+	return &stuckStore{
+		MemoryStore: memory.New(memory.Config{}),
+		got:         make(chan context.Context, 1),
+		release:     make(chan struct{}),
+	}
+}
+
+func (s *stuckStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (types.TValue, bool, error) {
+	<-ctx.Done()
+	s.got <- ctx
+	return types.TValue{}, false, ctx.Err()
+}
+
+func (s *stuckStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	<-s.release
+	return s.MemoryStore.GetMany(ctx, ns, keys, T)
+}
+
+func TestTimeout(t *testing.T) {
+	store := newStuckStore()
+	wrapped := timeout.New(timeout.Config{Read: 20 * time.Millisecond}).Wrap(store)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "trace")
+	start := time.Now()
+	_, _, err := wrapped.Get(ctx, "ns", "key", &struct{}{})
+
+	if took := time.Since(start); took < 20*time.Millisecond {
+		t.Fatalf("Get returned after %v, before the timeout of 20ms", took)
+	}
+
+	if !errors.Is(err, cache.ErrTimeout) {
+		t.Fatalf("got %v, want cache.ErrTimeout", err)
+	}
+
+	var timeoutErr *timeout.Error
+	if !errors.As(err, &timeoutErr) || timeoutErr.Op != "get" || timeoutErr.Timeout != 20*time.Millisecond {
+		t.Fatalf("got %v, want a *timeout.Error of the get", err)
+	}
+
+	var storeErr *cache.StoreError
+	if !errors.As(err, &storeErr) || storeErr.Namespace != "ns" || len(storeErr.Keys) != 1 || storeErr.Keys[0] != "key" {
+		t.Fatalf("got %v, want a *cache.StoreError with the namespace and key", err)
+	}
+
+	got := <-store.got
+	if got.Value(ctxKey{}) != "trace" {
+		t.Fatal("store did not get the ctx of the caller")
+	}
+
+	if !errors.Is(got.Err(), context.DeadlineExceeded) {
+		t.Fatalf("ctx of the store ended with %v, want context.DeadlineExceeded", got.Err())
+	}
+}
+
+func TestTimeoutStoreIgnoresContext(t *testing.T) {
+	store := newStuckStore()
+	defer close(store.release)
+
+	wrapped := timeout.New(timeout.Config{Read: time.Second, Batch: 20 * time.Millisecond}).Wrap(store)
+
+	start := time.Now()
+	_, err := wrapped.GetMany(context.Background(), "ns", []string{"a", "b"}, &struct{}{})
+
+	if !errors.Is(err, cache.ErrTimeout) {
+		t.Fatalf("got %v, want cache.ErrTimeout", err)
+	}
+
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Fatalf("GetMany returned after %v, want the Batch timeout of 20ms", took)
+	}
+}
+
+func TestTimeoutAsMiss(t *testing.T) {
+	store := newStuckStore()
+	close(store.release)
+
+	wrapped := timeout.New(timeout.Config{Read: 20 * time.Millisecond, ReadTimeoutAsMiss: true}).Wrap(store)
+
+	_, found, err := wrapped.Get(context.Background(), "ns", "key", &struct{}{})
+	if err != nil || found {
+		t.Fatalf("got found %v and %v, want a miss", found, err)
+	}
+	<-store.got
+}
+
+func TestTimeoutCallerCancelled(t *testing.T) {
+	store := newStuckStore()
+	wrapped := timeout.New(timeout.Config{Read: time.Hour}).Wrap(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := wrapped.Get(ctx, "ns", "key", &struct{}{})
+
+	if !errors.Is(err, context.Canceled) || errors.Is(err, cache.ErrTimeout) {
+		t.Fatalf("got %v, want context.Canceled and no timeout as the caller gave up", err)
+	}
+
+	if got := <-store.got; !errors.Is(got.Err(), context.Canceled) {
+		t.Fatalf("ctx of the store ended with %v, want context.Canceled", got.Err())
+	}
+}
@@ -0,0 +1,91 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/middleware/hashkey"
+	"github.com/steamsets/go-cache/middleware/timeout"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+func TestCapability(t *testing.T) {
+	wrap := func(store cache.Store) cache.Store {
+		return timeout.New(timeout.Config{Read: time.Second}).Wrap(hashkey.New(hashkey.Memcached).Wrap(store))
+	}
+
+	wrapped := wrap(memory.New(memory.Config{}))
+	if _, ok := cache.Capability[cache.ConditionalStore](wrapped); !ok {
+		t.Fatal("wrapped memory store is not a ConditionalStore")
+	}
+
+	if _, ok := cache.Capability[cache.Pinger](wrapped); ok {
+		t.Fatal("wrapped memory store is a Pinger, but memory has no Ping")
+	}
+
+	// embedding only the Store interface hides everything else the memory store implements
+	plain := wrap(struct{ cache.Store }{memory.New(memory.Config{})})
+	if _, ok := cache.Capability[cache.ConditionalStore](plain); ok {
+		t.Fatal("wrapped store without CompareAndSwap is a ConditionalStore")
+	}
+
+	if _, ok := cache.Capability[cache.CounterStore](plain); ok {
+		t.Fatal("wrapped store without counters is a CounterStore")
+	}
+
+	if _, ok := cache.Capability[cache.Pinger](wrap(&brokenStore{MemoryStore: memory.New(memory.Config{})})); !ok {
+		t.Fatal("wrapped store with Ping is not a Pinger")
+	}
+}
+
+func TestUpdateWrappedStoreWithoutCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New(memory.Config{})
+	store := hashkey.New(hashkey.Memcached).Wrap(counterOnlyStore{Store: inner, counters: inner})
+
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{store},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+	})
+
+	// the middleware implements CompareAndSwap, but the store below it doesn't, so Update takes a lease instead
+	value, err := ns.Update(ctx, "alice", func(old *user) (*user, error) {
+		return &user{Name: "alice"}, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value == nil || value.Name != "alice" {
+		t.Fatalf("got %v, want the updated value", value)
+	}
+}
+
+func TestCircuitBreakerWrappedStoreWithoutPing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// embedding only the Store interface hides the Ping of the broken store
+	broken := struct{ cache.Store }{&brokenStore{MemoryStore: memory.New(memory.Config{})}}
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{hashkey.New(hashkey.Memcached).Wrap(broken)},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+		CircuitBreaker: &cache.CircuitBreakerConfig{
+			MinRequests:  1,
+			Cooldown:     time.Hour,
+			PingInterval: time.Millisecond,
+		},
+	})
+
+	ns.Get(ctx, "alice")
+
+	// there is nothing to ping, so the circuit stays open until the cooldown is over
+	time.Sleep(30 * time.Millisecond)
+	if state := ns.Health()[0].State; state != cache.CircuitOpen {
+		t.Fatalf("circuit is %v, want it open until the cooldown is over", state)
+	}
+}
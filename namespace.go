@@ -2,40 +2,114 @@ package cache
 
 import (
 	"context"
-	"errors"
-	"fmt"
-	"strings"
-	"sync"
 	"time"
 
+	"github.com/steamsets/go-cache/pkg/clock"
 	"github.com/steamsets/go-cache/pkg/telemetry"
 	"github.com/steamsets/go-cache/pkg/types"
 )
 
+// Clock tells the time for every expiry decision, see cachetest.FakeClock
+type Clock = clock.Clock
+
 type Namespace[T any] struct {
 	fresh        time.Duration
 	stale        time.Duration
 	telemetry    bool
 	ns           types.TNamespace
 	store        tieredCache[T]
-	revalidating *sync.Map
+	revalidating *flightGroup[*T]
+	refreshAhead *refreshAhead
+	loader       func(context.Context, string) (*T, error)
+	batchLoader  func(context.Context, []string) ([]GetMany[T], error)
 }
 
+// NamespaceConfig holds everything that does not depend on the type of the namespace,
+// loaders and migrations are passed to NewNamespace as options
 type NamespaceConfig struct {
-	Stores    []Store
+	// Used as tiers with the namespace settings if Tiers is empty
+	Stores []Store
+	// Stores with their own settings, faster ones first
+	Tiers     []Tier
 	Telemetry bool
 	Fresh     time.Duration
 	Stale     time.Duration
+	// Decides when Set, SetMany and Remove succeed, the stores are always written at the same time.
+	// Defaults to WriteAll
+	WritePolicy WritePolicy
+	// Values found in a slower store are written back to the faster ones above it,
+	// if set that happens in the background instead of before the read returns
+	AsyncBackfill bool
+	// How Get asks the stores, e.g. hedging between two remote stores. Defaults to ReadSequential
+	ReadMode ReadMode
+	// How long ReadHedged waits for a store before the next one is asked as well, defaults to 10ms
+	HedgeDelay time.Duration
+	// Optionally skip stores that fail or are too slow for a while, reads from them are treated as misses
+	CircuitBreaker *CircuitBreakerConfig
+	// Optionally reload hot keys before they stop being fresh, stops once ctx is cancelled.
+	// Needs WithRefreshLoader or WithLoader
+	RefreshAhead *RefreshAheadConfig
+
+	// Optionally only write the first store synchronously and all others in the background,
+	// the queue is flushed once ctx is cancelled or Close is called, which waits for it
+	WriteBehind *WriteBehindConfig
+
+	// Written into every entry, entries of another version are treated as a miss. Bump it whenever T changes shape,
+	// entries that can't be decoded into T anymore are treated as a miss regardless
+	SchemaVersion int
+	// Remove entries of another SchemaVersion or that can't be decoded from the store they were found in.
+	// Entries of an older version are upgraded instead if there is a WithMigration for it
+	RemoveInvalidEntries bool
+
+	// How many loader calls FetchMany runs at once when there is no WithBatchLoader, defaults to 10
+	LoaderConcurrency int
+
+	// Decides when values stop being fresh or stale, defaults to the system clock.
+	// Stores keep their own clock, e.g. memory.Config.Clock
+	Clock Clock
 }
 
-func NewNamespace[T any](ns types.TNamespace, ctx context.Context, cfg NamespaceConfig) Namespace[T] {
-	return Namespace[T]{
+func NewNamespace[T any](ns types.TNamespace, ctx context.Context, cfg NamespaceConfig, opts ...Option[T]) Namespace[T] {
+	o := namespaceOptions[T]{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	n := Namespace[T]{
 		ns:           ns,
 		fresh:        cfg.Fresh,
 		stale:        cfg.Stale,
-		store:        newTieredCache[T](ns, cfg.Stores, cfg.Fresh, cfg.Stale, cfg.Telemetry),
-		revalidating: &sync.Map{},
+		store:        newTieredCache[T](ctx, ns, cfg, o.migrations),
+		revalidating: newFlightGroup[*T](),
+		loader:       o.loader,
+		batchLoader:  o.batchLoader,
+	}
+
+	if n.store.writeBehind != nil {
+		go n.store.writeBehind.run(ctx)
+	}
+
+	if n.batchLoader == nil && n.loader != nil {
+		n.batchLoader = batchFromLoader(n.loader, cfg.LoaderConcurrency)
+	}
+
+	if cfg.RefreshAhead != nil && (o.refreshLoader != nil || o.loader != nil) {
+		loader := o.refreshLoader
+		if loader == nil {
+			loader = o.loader
+		}
+		n.refreshAhead = newRefreshAhead(*cfg.RefreshAhead, cfg.Fresh, n.store.clock, func(ctx context.Context, key string) (time.Time, error) {
+			return n.reloadAhead(ctx, key, loader)
+		})
+
+		go n.refreshAhead.run(ctx)
 	}
+
+	return n
 }
 
 func (n Namespace[T]) Get(ctx context.Context, key string) (value *T, found bool, err error) {
@@ -46,6 +120,10 @@ func (n Namespace[T]) Get(ctx context.Context, key string) (value *T, found bool
 		telemetry.AttributeKV{Key: "namespace", Value: string(n.ns)},
 	)
 
+	if key == "" {
+		return nil, false, ErrEmptyKey
+	}
+
 	val, found, err := n.store.Get(ctx, n.ns, key)
 
 	if err != nil {
@@ -58,11 +136,13 @@ func (n Namespace[T]) Get(ctx context.Context, key string) (value *T, found bool
 
 	v := getT[T](val.Value)
 
-	if time.Now().After(val.StaleUntil) {
+	if n.store.clock.Now().After(val.StaleUntil) {
 		n.store.Remove(ctx, n.ns, []string{key})
 		return nil, false, nil
 	}
 
+	n.refreshAhead.touch(key, val.FreshUntil)
+
 	return v, found, nil
 }
 
@@ -75,7 +155,7 @@ func (n Namespace[T]) Set(ctx context.Context, key string, value T, opts *types.
 	)
 
 	if key == "" {
-		return errors.New("key is empty")
+		return ErrEmptyKey
 	}
 
 	return n.store.Set(ctx, n.ns, key, &value, opts)
@@ -96,7 +176,7 @@ func (n Namespace[T]) GetMany(ctx context.Context, keys []string) ([]GetMany[T],
 	)
 
 	if len(keys) == 0 {
-		return nil, errors.New("no keys provided")
+		return nil, ErrNoKeys
 	}
 
 	values, err := n.store.GetMany(ctx, n.ns, keys)
@@ -118,8 +198,10 @@ func (n Namespace[T]) GetMany(ctx context.Context, keys []string) ([]GetMany[T],
 			continue
 		}
 
-		if time.Now().After(val.StaleUntil) {
+		if n.store.clock.Now().After(val.StaleUntil) {
 			toRemove = append(toRemove, val.Key)
+		} else {
+			n.refreshAhead.touch(val.Key, val.FreshUntil)
 		}
 
 		v := getT[T](val.Value)
@@ -151,7 +233,7 @@ func (n Namespace[T]) SetMany(ctx context.Context, values []SetMany[*T], opts *t
 	defer span.End()
 
 	if len(values) == 0 {
-		return errors.New("no values provided")
+		return ErrNoValues
 	}
 
 	return n.store.SetMany(ctx, n.ns, values, opts)
@@ -168,12 +250,51 @@ func (n Namespace[T]) Remove(ctx context.Context, keys []string) error {
 	return n.store.Remove(ctx, n.ns, keys)
 }
 
+// Flush blocks until all queued write behind writes reached the lower tiers
+func (n Namespace[T]) Flush(ctx context.Context) error {
+	if n.store.writeBehind == nil {
+		return nil
+	}
+
+	return n.store.writeBehind.flush(ctx)
+}
+
+// Close stops the write behind queue and blocks until everything that was queued reached the lower tiers
+// or ctx is done. Set and SetMany keep working afterwards but write all tiers synchronously.
+// Call it before the process exits, cancelling the ctx of NewNamespace starts the same flush without waiting for it
+func (n Namespace[T]) Close(ctx context.Context) error {
+	if n.store.writeBehind == nil {
+		return nil
+	}
+
+	return n.store.writeBehind.close(ctx)
+}
+
+// WriteBehindStats returns the queue depth and counters of the write behind queue, it is empty if write behind is disabled
+func (n Namespace[T]) WriteBehindStats() WriteBehindStats {
+	if n.store.writeBehind == nil {
+		return WriteBehindStats{}
+	}
+
+	return n.store.writeBehind.stats()
+}
+
+// SchemaStats returns how many entries were treated as a miss because of their SchemaVersion or because they couldn't be decoded
+func (n Namespace[T]) SchemaStats() SchemaStats {
+	return n.store.schema.stats()
+}
+
+// Health returns the circuit state of every tier, they are always closed if circuit breakers are disabled
+func (n Namespace[T]) Health() []TierHealth {
+	return n.store.health()
+}
+
 func (n Namespace[T]) Swr(ctx context.Context, key string, refreshFromOrigin func(string) (*T, error)) (*T, error) {
 	ctx, span := telemetry.NewSpan(ctx, "namespace.swr")
 	defer span.End()
 
 	if key == "" {
-		return nil, errors.New("key is empty")
+		return nil, ErrEmptyKey
 	}
 
 	value, found, err := n.store.Get(ctx, n.ns, key)
@@ -181,17 +302,20 @@ func (n Namespace[T]) Swr(ctx context.Context, key string, refreshFromOrigin fun
 		return nil, err
 	}
 
-	now := time.Now()
+	now := n.store.clock.Now()
 
 	if found {
+		n.refreshAhead.touch(key, value.FreshUntil)
+
 		if now.After(value.FreshUntil) {
 			newValue, error := n.deduplicateLoadFromOrigin(ctx, n.ns, key, refreshFromOrigin)
 			if error != nil {
 				return nil, error
 			}
 
+			// the stale value is still returned, the next call tries to write the new one again
 			if err := n.store.Set(ctx, n.ns, key, newValue, nil); err != nil {
-				return nil, err
+				telemetry.RecordError(span, err)
 			}
 		}
 
@@ -205,13 +329,36 @@ func (n Namespace[T]) Swr(ctx context.Context, key string, refreshFromOrigin fun
 		return nil, error
 	}
 
+	// the value was loaded, failing to cache it only means the next call loads it again
 	if err := n.store.Set(ctx, n.ns, key, newValue, nil); err != nil {
-		return nil, err
+		telemetry.RecordError(span, err)
 	}
 
 	return newValue, nil
 }
 
+// reloadAhead is used by the refresh ahead scheduler and returns until when the new value is fresh
+func (n Namespace[T]) reloadAhead(ctx context.Context, key string, loader func(context.Context, string) (*T, error)) (time.Time, error) {
+	value, err := n.revalidating.do(ctx, key, func() (*T, error) {
+		return loader(ctx, key)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// the origin does not know the key (anymore), so it will just expire
+	if value == nil {
+		return time.Time{}, nil
+	}
+
+	fresh, _ := getStaleFreshTime(n.store.clock.Now(), n.fresh, n.stale, nil)
+	if err := n.store.Set(ctx, n.ns, key, value, nil); err != nil {
+		return time.Time{}, err
+	}
+
+	return fresh, nil
+}
+
 func getT[T any](val interface{}) *T {
 	if v1, ok := val.(T); ok {
 		return &v1
@@ -229,7 +376,7 @@ func (n Namespace[T]) SwrMany(ctx context.Context, keys []string, refreshFromOri
 	defer span.End()
 
 	if len(keys) == 0 {
-		return nil, errors.New("no keys provided")
+		return nil, ErrNoKeys
 	}
 
 	values, err := n.store.GetMany(ctx, n.ns, keys)
@@ -247,10 +394,12 @@ func (n Namespace[T]) SwrMany(ctx context.Context, keys []string, refreshFromOri
 			continue
 		}
 
-		if time.Now().After(val.StaleUntil) {
+		if n.store.clock.Now().After(val.StaleUntil) {
 			keysToFetchFromOrigin = append(keysToFetchFromOrigin, val.Key)
 			// We want to get the new value from the origin but will remove
 			// the result from the origin and just keep this value in the response
+		} else {
+			n.refreshAhead.touch(val.Key, val.FreshUntil)
 		}
 
 		v := getT[T](val.Value)
@@ -275,8 +424,13 @@ func (n Namespace[T]) SwrMany(ctx context.Context, keys []string, refreshFromOri
 			}
 		}
 
-		valuesToSet := make([]SetMany[*T], 0)
-		for _, v := range returnMap {
+		valuesToSet := make([]SetMany[*T], 0, len(returnMap))
+		for _, key := range keys {
+			v, ok := returnMap[key]
+			if !ok {
+				continue
+			}
+
 			valuesToSet = append(valuesToSet, SetMany[*T]{
 				Value: v.Value,
 				Key:   v.Key,
@@ -284,96 +438,93 @@ func (n Namespace[T]) SwrMany(ctx context.Context, keys []string, refreshFromOri
 			})
 		}
 
+		// same as in Swr, the loaded values are returned even if they could not be cached
 		if err := n.store.SetMany(ctx, n.ns, valuesToSet, nil); err != nil {
-			return nil, err
+			telemetry.RecordError(span, err)
 		}
 	}
 
+	// in the order of keys, like GetMany
+	returnValues := make([]GetMany[T], 0, len(keys))
 	for _, key := range keys {
-		if _, ok := returnMap[key]; !ok {
-			returnMap[key] = GetMany[T]{
+		v, ok := returnMap[key]
+		if !ok {
+			v = GetMany[T]{
 				Key:   key,
 				Value: nil,
 				Found: false,
 			}
 		}
-	}
 
-	returnValues := make([]GetMany[T], 0)
-	for _, v := range returnMap {
 		returnValues = append(returnValues, v)
 	}
 
 	return returnValues, nil
 }
 
-type deduplicateEntry[T any] struct {
-	value *T
-	err   error
-}
-
-type deduplicateManyEntry[T any] struct {
-	value []GetMany[T]
-	err   error
-}
-
 func (n Namespace[T]) deduplicateLoadFromOrigin(ctx context.Context, ns types.TNamespace, key string, refreshFromOrigin func(string) (*T, error)) (*T, error) {
 	ctx, span := telemetry.NewSpan(ctx, "namespace.deduplicate-load-from-origin")
 	defer span.End()
 
-	revalidateKey := fmt.Sprintf("%s::%s", ns, key)
-
-	// if we are currently revalidating this key, wait for the result (hopefully)
-	if val, ok := n.revalidating.Load(revalidateKey); ok {
-		future := val.(chan deduplicateEntry[T])
-		result := <-future
-		return result.value, result.err
-	}
-
-	future := make(chan deduplicateEntry[T], 1)
+	// if we are currently revalidating this key, we wait for that result instead of asking the origin again
+	return n.revalidating.do(ctx, key, func() (*T, error) {
+		_, span2 := telemetry.NewSpan(ctx, "namespace.refreshFromOrigin")
+		defer span2.End()
+		telemetry.WithAttributes(span2,
+			telemetry.AttributeKV{Key: "key", Value: key},
+			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+		)
 
-	n.revalidating.Store(revalidateKey, future)
+		value, err := refreshFromOrigin(key)
+		telemetry.RecordError(span2, err)
 
-	defer n.revalidating.Delete(revalidateKey)
-
-	_, span2 := telemetry.NewSpan(ctx, "namespace.refreshFromOrigin")
-	value, err := refreshFromOrigin(key)
-	span2.End()
-
-	// Send the result through the channel
-	future <- deduplicateEntry[T]{value, err}
-
-	return value, err
+		return value, err
+	})
 }
 
 func (n Namespace[T]) deduplicateLoadFromOriginMany(ctx context.Context, ns types.TNamespace, keys []string, refreshFromOrigin func([]string) ([]GetMany[T], error)) ([]GetMany[T], error) {
 	ctx, span := telemetry.NewSpan(ctx, "namespace.deduplicate-load-from-origin-many")
 	defer span.End()
 
-	revalidateKey := fmt.Sprintf("%s::%s", ns, strings.Join(keys, ","))
-
-	// if we are currently revalidating this key, wait for the result (hopefully)
-	if val, ok := n.revalidating.Load(revalidateKey); ok {
-		future := val.(chan deduplicateManyEntry[T])
-		result := <-future
-		return result.value, result.err
-	}
-
-	future := make(chan deduplicateManyEntry[T], 1)
+	// keys that are already being revalidated, by Swr or another batch, are awaited
+	// and only the remaining ones are requested from the origin
+	values, err := n.revalidating.doMany(ctx, keys, func(keysToLoad []string) (map[string]*T, error) {
+		_, span2 := telemetry.NewSpan(ctx, "namespace.refreshFromOrigin")
+		defer span2.End()
+		telemetry.WithAttributes(span2,
+			telemetry.AttributeKV{Key: "keys", Value: keysToLoad},
+			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+		)
+
+		values, err := refreshFromOrigin(keysToLoad)
+		if err != nil {
+			telemetry.RecordError(span2, err)
+			return nil, err
+		}
 
-	n.revalidating.Store(revalidateKey, future)
-	defer n.revalidating.Delete(revalidateKey)
+		loaded := make(map[string]*T, len(values))
+		for _, v := range values {
+			if v.Value != nil {
+				loaded[v.Key] = v.Value
+			}
+		}
 
-	_, span2 := telemetry.NewSpan(ctx, "namespace.refreshFromOrigin")
-	telemetry.WithAttributes(span2,
-		telemetry.AttributeKV{Key: "keys", Value: keys},
-		telemetry.AttributeKV{Key: "namespace", Value: string(n.ns)},
-	)
-	values, err := refreshFromOrigin(keys)
-	span2.End()
+		return loaded, nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Send the result through the channel
-	future <- deduplicateManyEntry[T]{values, err}
+	ret := make([]GetMany[T], 0)
+	for _, key := range keys {
+		if v, ok := values[key]; ok && v != nil {
+			ret = append(ret, GetMany[T]{
+				Key:   key,
+				Value: v,
+				Found: true,
+			})
+		}
+	}
 
-	return values, err
+	return ret, nil
 }
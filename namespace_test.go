@@ -0,0 +1,106 @@
+package cache_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/cachetest"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+func TestSwrManyOrder(t *testing.T) {
+	ctx := context.Background()
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{memory.New(memory.Config{})},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+	})
+
+	for _, name := range []string{"bob", "dave"} {
+		if err := ns.Set(ctx, name, user{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// cached and loaded keys are mixed, carol is neither
+	keys := []string{"eve", "dave", "alice", "carol", "bob", "frank", "grace", "heidi"}
+	values, err := ns.SwrMany(ctx, keys, func(keys []string) ([]cache.GetMany[user], error) {
+		loaded := make([]cache.GetMany[user], 0, len(keys))
+		for _, key := range keys {
+			if key == "carol" {
+				continue
+			}
+			loaded = append(loaded, cache.GetMany[user]{Key: key, Value: &user{Name: key}, Found: true})
+		}
+		return loaded, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != len(keys) {
+		t.Fatalf("got %d values, want one for each of the %d keys", len(values), len(keys))
+	}
+
+	for i, key := range keys {
+		if values[i].Key != key {
+			t.Fatalf("got %s at %d, want the values in the order of the keys %v", values[i].Key, i, keys)
+		}
+
+		if key == "carol" {
+			if values[i].Found || values[i].Value != nil {
+				t.Fatalf("got %+v for a key the origin doesn't have, want a miss", values[i])
+			}
+			continue
+		}
+
+		if !values[i].Found || values[i].Value == nil || values[i].Value.Name != key {
+			t.Fatalf("got %+v for %s, want its value", values[i], key)
+		}
+	}
+}
+
+func TestSwrClock(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{memory.New(memory.Config{Clock: clock})},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+		Clock:  clock,
+	})
+
+	loads := 0
+	load := func(key string) (*user, error) {
+		loads++
+		return &user{Name: fmt.Sprintf("%s-%d", key, loads)}, nil
+	}
+
+	for _, step := range []struct {
+		name    string
+		advance time.Duration
+		want    string
+		loads   int
+	}{
+		{name: "miss", want: "alice-1", loads: 1},
+		{name: "fresh", advance: 59 * time.Second, want: "alice-1", loads: 1},
+		// the stale value is returned, the loaded one is cached for the next call
+		{name: "stale", advance: 2 * time.Second, want: "alice-1", loads: 2},
+		{name: "revalidated", advance: 30 * time.Second, want: "alice-2", loads: 2},
+		{name: "expired", advance: time.Hour, want: "alice-3", loads: 3},
+	} {
+		clock.Advance(step.advance)
+
+		value, err := ns.Swr(ctx, "alice", load)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if value == nil || value.Name != step.want || loads != step.loads {
+			t.Fatalf("%s: got %v after %d loads, want %s after %d", step.name, value, loads, step.want, step.loads)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/goccy/go-json"
+)
+
+// Option sets the parts of a namespace that depend on T, they are passed to NewNamespace after the NamespaceConfig
+type Option[T any] func(*namespaceOptions[T])
+
+type namespaceOptions[T any] struct {
+	loader        func(context.Context, string) (*T, error)
+	batchLoader   func(context.Context, []string) ([]GetMany[T], error)
+	refreshLoader func(context.Context, string) (*T, error)
+	// keyed by the schema version an entry was written with
+	migrations map[int]func(json.RawMessage) (*T, error)
+}
+
+// WithLoader is used by Fetch and by RefreshAhead if there is no WithRefreshLoader
+func WithLoader[T any](loader func(ctx context.Context, key string) (*T, error)) Option[T] {
+	return func(o *namespaceOptions[T]) {
+		o.loader = loader
+	}
+}
+
+// WithBatchLoader is used by FetchMany, without it the loader of WithLoader is called for every key instead
+func WithBatchLoader[T any](loader func(ctx context.Context, keys []string) ([]GetMany[T], error)) Option[T] {
+	return func(o *namespaceOptions[T]) {
+		o.batchLoader = loader
+	}
+}
+
+// WithRefreshLoader reloads hot keys for NamespaceConfig.RefreshAhead instead of the loader of WithLoader
+func WithRefreshLoader[T any](loader func(ctx context.Context, key string) (*T, error)) Option[T] {
+	return func(o *namespaceOptions[T]) {
+		o.refreshLoader = loader
+	}
+}
+
+// WithMigration upgrades entries that were written with an older NamespaceConfig.SchemaVersion. The entry is passed
+// as json and the upgraded value is written back to the store it was found in instead of loading it from the origin again
+func WithMigration[T any](version int, migrate func(raw json.RawMessage) (*T, error)) Option[T] {
+	return func(o *namespaceOptions[T]) {
+		if o.migrations == nil {
+			o.migrations = make(map[int]func(json.RawMessage) (*T, error))
+		}
+
+		o.migrations[version] = migrate
+	}
+}
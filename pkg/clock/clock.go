@@ -0,0 +1,24 @@
+package clock
+
+import "time"
+
+// Clock tells the time for every expiry decision, so tests can move it forward instead of sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the clock of the system
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// OrReal returns c, or the real clock if c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real{}
+	}
+
+	return c
+}
@@ -13,6 +13,10 @@ type TValue struct {
 	Value      interface{}
 	FreshUntil time.Time
 	StaleUntil time.Time
+	// Version of the namespace schema the value was written with, see NamespaceConfig.SchemaVersion
+	SchemaVersion int `json:",omitempty"`
+	// Revision of the entry, every write through a Namespace stores a new and higher one. 0 for values written to the store directly
+	Revision uint64 `json:",omitempty"`
 }
 
 type TNamespace string
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/clock"
+)
+
+// counterBackend works with any cache.CounterStore, every window is its own counter.
+// Rejected requests give their cost back, so the count can be exceeded for a moment under contention.
+type counterBackend struct {
+	cfg   Config
+	store cache.Store
+	// nil if the store has no time of its own, Config.Clock is used then
+	clock cache.TimeStore
+}
+
+func (c *counterBackend) allow(ctx context.Context, id string, cost int64) (Result, error) {
+	store, ok := cache.Capability[cache.CounterStore](c.store)
+	if !ok || c.cfg.Algorithm == TokenBucket {
+		return Result{}, cache.NewStoreError(c.store.Name(), c.cfg.Namespace, "ratelimit", []string{id}, cache.ErrUnsupported, cache.ErrUnsupported)
+	}
+
+	now, err := c.now(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	start := windowStart(now, c.cfg.Window)
+
+	var previous int64
+	if c.cfg.Algorithm == SlidingWindow {
+		count, _, err := store.Counter(ctx, c.cfg.Namespace, c.key(id, start.Add(-c.cfg.Window)))
+		if err != nil {
+			return Result{}, err
+		}
+
+		weight := float64(c.cfg.Window-now.Sub(start)) / float64(c.cfg.Window)
+		previous = int64(math.Floor(float64(count) * weight))
+	}
+
+	// the previous window is still needed by the sliding window while the current one runs
+	key := c.key(id, start)
+	current, err := store.Increment(ctx, c.cfg.Namespace, key, cost, 2*c.cfg.Window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	count := previous + current
+	if count > c.cfg.Limit {
+		if _, err := store.Increment(ctx, c.cfg.Namespace, key, -cost, 2*c.cfg.Window); err != nil {
+			return Result{}, err
+		}
+
+		return Result{
+			Allowed:   false,
+			Remaining: c.cfg.Limit - (count - cost),
+			Reset:     start.Add(c.cfg.Window),
+		}, nil
+	}
+
+	return Result{
+		Allowed:   true,
+		Remaining: c.cfg.Limit - count,
+		Reset:     start.Add(c.cfg.Window),
+	}, nil
+}
+
+// now returns the time of the store, so instances with skewed clocks still share the same windows
+func (c *counterBackend) now(ctx context.Context) (time.Time, error) {
+	if c.clock == nil {
+		return clock.OrReal(c.cfg.Clock).Now(), nil
+	}
+
+	return c.clock.Time(ctx)
+}
+
+func (c *counterBackend) key(id string, window time.Time) string {
+	return id + "::" + strconv.FormatInt(window.UnixMilli(), 10)
+}
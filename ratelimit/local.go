@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+// local keeps the state of every id in the memory store, so it is bounded and evicted like any other value
+type local struct {
+	cfg   Config
+	store *memory.MemoryStore
+}
+
+func newLocal(cfg Config, store *memory.MemoryStore) *local {
+	return &local{cfg: cfg, store: store}
+}
+
+type localState struct {
+	mu sync.Mutex
+	// start of the current window
+	window   time.Time
+	current  int64
+	previous int64
+	tokens   float64
+	last     time.Time
+}
+
+func (l *local) allow(ctx context.Context, id string, cost int64) (Result, error) {
+	// the time of the store, otherwise states would expire by another clock than the windows
+	now, err := l.store.Time(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	state, err := l.state(ctx, id, now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	var result Result
+	switch l.cfg.Algorithm {
+	case TokenBucket:
+		result = state.bucket(l.cfg, now, cost)
+	default:
+		result = state.slide(l.cfg, now, cost)
+	}
+
+	// every call keeps the state around for two more windows, the previous one is still needed by SlidingWindow
+	staleUntil := now.Add(2 * l.cfg.Window)
+	if err := l.store.Set(ctx, l.cfg.Namespace, id, types.TValue{Key: id, Value: state, FreshUntil: staleUntil, StaleUntil: staleUntil}); err != nil {
+		return Result{}, err
+	}
+
+	return result, nil
+}
+
+func (l *local) state(ctx context.Context, id string, now time.Time) (*localState, error) {
+	for {
+		value, found, err := l.store.Get(ctx, l.cfg.Namespace, id, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if state, ok := value.Value.(*localState); found && ok && now.Before(value.StaleUntil) {
+			return state, nil
+		}
+
+		state := &localState{tokens: float64(l.cfg.Limit), last: now}
+		staleUntil := now.Add(2 * l.cfg.Window)
+
+		err = l.store.SetIfAbsent(ctx, l.cfg.Namespace, id, types.TValue{Key: id, Value: state, FreshUntil: staleUntil, StaleUntil: staleUntil})
+		if err == nil {
+			return state, nil
+		}
+
+		// someone else created it in between
+		if !errors.Is(err, cache.ErrConflict) {
+			return nil, err
+		}
+	}
+}
+
+// slide handles both window algorithms, FixedWindow just ignores the previous window
+func (s *localState) slide(cfg Config, now time.Time, cost int64) Result {
+	start := windowStart(now, cfg.Window)
+
+	switch {
+	case start.Equal(s.window):
+	case start.Equal(s.window.Add(cfg.Window)):
+		s.previous, s.current = s.current, 0
+	default:
+		s.previous, s.current = 0, 0
+	}
+	s.window = start
+
+	count := s.current
+	if cfg.Algorithm == SlidingWindow {
+		weight := float64(cfg.Window-now.Sub(start)) / float64(cfg.Window)
+		count += int64(math.Floor(float64(s.previous) * weight))
+	}
+
+	allowed := count+cost <= cfg.Limit
+	if allowed {
+		s.current += cost
+		count += cost
+	}
+
+	return Result{
+		Allowed:   allowed,
+		Remaining: cfg.Limit - count,
+		Reset:     start.Add(cfg.Window),
+	}
+}
+
+func (s *localState) bucket(cfg Config, now time.Time, cost int64) Result {
+	rate := float64(cfg.Limit) / float64(cfg.Window)
+
+	s.tokens = math.Min(float64(cfg.Limit), s.tokens+float64(now.Sub(s.last))*rate)
+	s.last = now
+
+	allowed := s.tokens >= float64(cost)
+	if allowed {
+		s.tokens -= float64(cost)
+	}
+
+	return Result{
+		Allowed:   allowed,
+		Remaining: int64(math.Floor(s.tokens)),
+		Reset:     now.Add(time.Duration((float64(cfg.Limit) - s.tokens) / rate)),
+	}
+}
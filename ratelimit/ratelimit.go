@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+	"github.com/steamsets/go-cache/store/redis"
+)
+
+type Algorithm int
+
+const (
+	// Counts requests per window, all of them are allowed again once the window is over. This is the default
+	FixedWindow Algorithm = iota
+	// Weights the count of the previous window by how much of it still overlaps, so there are no bursts at window boundaries
+	SlidingWindow
+	// Holds up to Limit tokens that are refilled evenly over Window, allows short bursts up to Limit
+	TokenBucket
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case SlidingWindow:
+		return "sliding-window"
+	case TokenBucket:
+		return "token-bucket"
+	default:
+		return "fixed-window"
+	}
+}
+
+type Config struct {
+	// Redis is used with lua scripts, the memory store or no store keeps the limits local to this instance.
+	// Both are found behind middlewares as well, which are skipped then.
+	// Any other store has to implement cache.CounterStore and only supports the window algorithms,
+	// windows use the time of the store if it implements cache.TimeStore
+	Store     cache.Store
+	Namespace types.TNamespace
+	Algorithm Algorithm
+	// Cost allowed per Window, or the size of the bucket
+	Limit int64
+	// Length of a window, or the time the bucket takes to refill completely
+	Window time.Duration
+	// Time of the memory store that is created if Store is nil and of stores without a time of their own,
+	// defaults to the system clock. A memory store that is passed in keeps its own, see memory.Config.Clock
+	Clock cache.Clock
+}
+
+type Result struct {
+	Allowed bool
+	// Cost that is still allowed until Reset
+	Remaining int64
+	// When the window ends or the bucket is full again
+	Reset time.Time
+}
+
+type Limiter struct {
+	cfg     Config
+	backend backend
+}
+
+type backend interface {
+	allow(ctx context.Context, id string, cost int64) (Result, error)
+}
+
+func New(cfg Config) *Limiter {
+	if cfg.Limit <= 0 {
+		panic("Limit has to be positive")
+	}
+
+	if cfg.Window < time.Millisecond {
+		panic("Window has to be at least a millisecond")
+	}
+
+	if cfg.Namespace == "" {
+		cfg.Namespace = "ratelimit"
+	}
+
+	l := &Limiter{cfg: cfg}
+
+	switch store := cache.UnwrapStore(cfg.Store).(type) {
+	case nil:
+		l.backend = newLocal(cfg, memory.New(memory.Config{Clock: cfg.Clock}))
+	case *memory.MemoryStore:
+		l.backend = newLocal(cfg, store)
+	case *redis.RedisStore:
+		l.backend = &redisBackend{cfg: cfg, store: store}
+	default:
+		// the middlewares are kept, only the time is asked from the store they wrap
+		clock, _ := store.(cache.TimeStore)
+		l.backend = &counterBackend{cfg: cfg, store: cfg.Store, clock: clock}
+	}
+
+	return l
+}
+
+// Allow takes cost from the limit of id, nothing is taken if it is not allowed
+func (l *Limiter) Allow(ctx context.Context, id string, cost int64) (Result, error) {
+	ctx, span := telemetry.NewSpan(ctx, "ratelimit.allow")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "id", Value: id},
+		telemetry.AttributeKV{Key: "namespace", Value: string(l.cfg.Namespace)},
+		telemetry.AttributeKV{Key: "algorithm", Value: l.cfg.Algorithm.String()},
+		telemetry.AttributeKV{Key: "cost", Value: cost},
+	)
+
+	if id == "" {
+		return Result{}, cache.ErrEmptyKey
+	}
+
+	if cost <= 0 {
+		cost = 1
+	}
+
+	result, err := l.backend.allow(ctx, id, cost)
+	if err != nil {
+		telemetry.RecordError(span, err)
+		return Result{}, err
+	}
+
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "allowed", Value: result.Allowed},
+		telemetry.AttributeKV{Key: "remaining", Value: result.Remaining},
+	)
+
+	return result, nil
+}
+
+// windowStart returns the start of the window now is in
+func windowStart(now time.Time, window time.Duration) time.Time {
+	return time.UnixMilli(now.UnixMilli() - now.UnixMilli()%window.Milliseconds())
+}
@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache/cachetest"
+	"github.com/steamsets/go-cache/middleware/retry"
+	"github.com/steamsets/go-cache/middleware/timeout"
+	"github.com/steamsets/go-cache/store/libsql"
+	"github.com/steamsets/go-cache/store/memory"
+	"github.com/steamsets/go-cache/store/redis"
+	_ "modernc.org/sqlite"
+)
+
+func TestNewUnwrapsMiddlewares(t *testing.T) {
+	retried := retry.New(retry.Policy{})
+	timedOut := timeout.New(timeout.Config{})
+
+	l := New(Config{
+		Store:  retried.Wrap(timedOut.Wrap(redis.New(redis.Config{}))),
+		Limit:  10,
+		Window: time.Second,
+	})
+	if _, ok := l.backend.(*redisBackend); !ok {
+		t.Fatalf("wrapped redis store uses %T, want the lua scripts", l.backend)
+	}
+
+	l = New(Config{
+		Store:  retried.Wrap(memory.New(memory.Config{})),
+		Limit:  10,
+		Window: time.Second,
+	})
+	if _, ok := l.backend.(*local); !ok {
+		t.Fatalf("wrapped memory store uses %T, want the local backend", l.backend)
+	}
+}
+
+func TestCounterBackendStoreTime(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE cache (key TEXT PRIMARY KEY, fresh_until INTEGER, stale_until INTEGER,
+		schema_version INTEGER NOT NULL DEFAULT 0, revision INTEGER NOT NULL DEFAULT 0, value TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the store is an hour behind this instance
+	storeTime := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	store := libsql.New(libsql.Config{DB: db, Clock: cachetest.NewFakeClock(storeTime)})
+
+	l := New(Config{
+		Store:  retry.New(retry.Policy{}).Wrap(store),
+		Limit:  2,
+		Window: time.Minute,
+	})
+	if _, ok := l.backend.(*counterBackend); !ok {
+		t.Fatalf("libsql store uses %T, want the counter backend", l.backend)
+	}
+
+	result, err := l.Allow(context.Background(), "user", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := storeTime.Add(time.Minute); !result.Reset.Equal(want) {
+		t.Fatalf("window resets at %v, want %v from the time of the store", result.Reset, want)
+	}
+}
+
+func TestLocalClock(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now().Add(-24 * time.Hour).Truncate(time.Minute))
+
+	for name, cfg := range map[string]Config{
+		"own store":    {Clock: clock},
+		"memory store": {Store: memory.New(memory.Config{Clock: clock})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock.Advance(time.Hour)
+			cfg.Limit = 2
+			cfg.Window = time.Minute
+			l := New(cfg)
+
+			for i := range 2 {
+				result, err := l.Allow(ctx, "user", 1)
+				if err != nil || !result.Allowed {
+					t.Fatalf("request %d got %+v and %v, want it allowed", i, result, err)
+				}
+
+				if want := clock.Now().Add(time.Minute); !result.Reset.Equal(want) {
+					t.Fatalf("window resets at %v, want %v from the clock", result.Reset, want)
+				}
+			}
+
+			if result, err := l.Allow(ctx, "user", 1); err != nil || result.Allowed {
+				t.Fatalf("got %+v and %v, want the third request of the window denied", result, err)
+			}
+
+			clock.Advance(time.Minute)
+			if result, err := l.Allow(ctx, "user", 1); err != nil || !result.Allowed || result.Remaining != 1 {
+				t.Fatalf("got %+v and %v, want the next window to start over", result, err)
+			}
+
+			// the state expired in the store after two windows, which has to be a new one for the limiter as well
+			clock.Advance(3 * time.Minute)
+			if result, err := l.Allow(ctx, "user", 2); err != nil || !result.Allowed || result.Remaining != 0 {
+				t.Fatalf("got %+v and %v, want the whole limit after the state expired", result, err)
+			}
+		})
+	}
+}
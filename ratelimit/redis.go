@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/rueidis"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/store/redis"
+)
+
+// The scripts use the time of redis, so instances with skewed clocks still share the same windows.
+// Every id is a single hash, which keeps the scripts usable in a cluster.
+
+var fixedWindow = rueidis.NewLuaScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window, limit, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local start = now - (now % window)
+
+local state = redis.call('HMGET', KEYS[1], 'w', 'c')
+local count = 0
+if tonumber(state[1]) == start then
+	count = tonumber(state[2]) or 0
+end
+
+local allowed = 0
+if count + cost <= limit then
+	count = count + cost
+	allowed = 1
+	redis.call('HSET', KEYS[1], 'w', start, 'c', count)
+	redis.call('PEXPIRE', KEYS[1], start + window - now)
+end
+
+return {allowed, limit - count, start + window}
+`)
+
+var slidingWindow = rueidis.NewLuaScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window, limit, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local start = now - (now % window)
+
+local state = redis.call('HMGET', KEYS[1], 'w', 'c', 'p')
+local w = tonumber(state[1])
+local current, previous = 0, 0
+if w == start then
+	current = tonumber(state[2]) or 0
+	previous = tonumber(state[3]) or 0
+elseif w == start - window then
+	previous = tonumber(state[2]) or 0
+end
+
+local count = current + math.floor(previous * (window - (now - start)) / window)
+
+local allowed = 0
+if count + cost <= limit then
+	current = current + cost
+	count = count + cost
+	allowed = 1
+	redis.call('HSET', KEYS[1], 'w', start, 'c', current, 'p', previous)
+	redis.call('PEXPIRE', KEYS[1], start + 2 * window - now)
+end
+
+return {allowed, limit - count, start + window}
+`)
+
+var tokenBucket = rueidis.NewLuaScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window, limit, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local rate = limit / window
+
+local state = redis.call('HMGET', KEYS[1], 't', 'l')
+local tokens = tonumber(state[1]) or limit
+local last = tonumber(state[2]) or now
+tokens = math.min(limit, tokens + (now - last) * rate)
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 't', tostring(tokens), 'l', now)
+redis.call('PEXPIRE', KEYS[1], window)
+
+return {allowed, math.floor(tokens), now + math.ceil((limit - tokens) / rate)}
+`)
+
+type redisBackend struct {
+	cfg   Config
+	store *redis.RedisStore
+}
+
+func (r *redisBackend) allow(ctx context.Context, id string, cost int64) (Result, error) {
+	script := fixedWindow
+	switch r.cfg.Algorithm {
+	case SlidingWindow:
+		script = slidingWindow
+	case TokenBucket:
+		script = tokenBucket
+	}
+
+	key := r.store.CreateCacheKey(r.cfg.Namespace, id)
+	resp, err := script.Exec(
+		ctx,
+		r.store.Client(),
+		[]string{key},
+		[]string{
+			strconv.FormatInt(r.cfg.Window.Milliseconds(), 10),
+			strconv.FormatInt(r.cfg.Limit, 10),
+			strconv.FormatInt(cost, 10),
+		},
+	).AsIntSlice()
+	if err != nil {
+		return Result{}, cache.NewStoreError(r.store.Name(), r.cfg.Namespace, "ratelimit", []string{id}, nil, err)
+	}
+
+	if len(resp) != 3 {
+		return Result{}, cache.NewStoreError(r.store.Name(), r.cfg.Namespace, "ratelimit", []string{id}, cache.ErrDecode, fmt.Errorf("expected 3 values, got %d", len(resp)))
+	}
+
+	return Result{
+		Allowed:   resp[0] == 1,
+		Remaining: resp[1],
+		Reset:     time.UnixMilli(resp[2]),
+	}, nil
+}
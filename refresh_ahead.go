@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/clock"
+	"github.com/steamsets/go-cache/pkg/telemetry"
+)
+
+// RefreshAheadConfig needs a loader, either WithRefreshLoader or WithLoader passed to NewNamespace
+type RefreshAheadConfig struct {
+	// How long before FreshUntil a hot key gets reloaded, defaults to a tenth of the namespace Fresh duration
+	Window time.Duration
+	// How often the tracked keys are checked, defaults to 1 second
+	Interval time.Duration
+	// Amount of accesses a key needs to count as hot, hits are halved on every check. Defaults to 2
+	MinHits int
+	// Maximum amount of keys that are tracked, new keys are ignored once it is reached. Defaults to 1000
+	MaxKeys int
+	// Maximum amount of reloads running at the same time, defaults to 4
+	Concurrency int
+	// Reloads are moved forward by a random duration up to Jitter so hot keys don't all hit the origin at once
+	Jitter time.Duration
+}
+
+type refreshAheadKey struct {
+	hits       int
+	freshUntil time.Time
+	jitter     time.Duration
+	refreshing bool
+}
+
+// refreshAhead keeps track of how often keys are read and reloads the hot ones
+// shortly before they stop being fresh, so they never go stale.
+type refreshAhead struct {
+	cfg    RefreshAheadConfig
+	reload func(ctx context.Context, key string) (time.Time, error)
+	// checks run every Interval of real time, whether a key is due is decided by this clock
+	clock clock.Clock
+
+	mu   sync.Mutex
+	keys map[string]*refreshAheadKey
+}
+
+func newRefreshAhead(cfg RefreshAheadConfig, fresh time.Duration, clock clock.Clock, reload func(ctx context.Context, key string) (time.Time, error)) *refreshAhead {
+	if cfg.Window <= 0 {
+		cfg.Window = fresh / 10
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+
+	if cfg.MinHits <= 0 {
+		cfg.MinHits = 2
+	}
+
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 1000
+	}
+
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+
+	return &refreshAhead{
+		cfg:    cfg,
+		reload: reload,
+		clock:  clock,
+		keys:   make(map[string]*refreshAheadKey),
+	}
+}
+
+// touch records an access to a key that was served from the cache
+func (r *refreshAhead) touch(key string, freshUntil time.Time) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tracked, ok := r.keys[key]
+	if !ok {
+		if len(r.keys) >= r.cfg.MaxKeys {
+			return
+		}
+
+		tracked = &refreshAheadKey{}
+		if r.cfg.Jitter > 0 {
+			tracked.jitter = rand.N(r.cfg.Jitter)
+		}
+		r.keys[key] = tracked
+	}
+
+	tracked.hits++
+	if freshUntil.After(tracked.freshUntil) {
+		tracked.freshUntil = freshUntil
+	}
+}
+
+// run checks the tracked keys every interval until ctx is cancelled,
+// it only returns once all reloads that were already started are done.
+func (r *refreshAhead) run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	semaphore := make(chan struct{}, r.cfg.Concurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, key := range r.due(r.clock.Now()) {
+			select {
+			case <-ctx.Done():
+				r.done(key, time.Time{})
+				continue
+			case semaphore <- struct{}{}:
+			}
+
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				r.refresh(ctx, key)
+			}(key)
+		}
+	}
+}
+
+// due returns the hot keys that are about to stop being fresh and forgets the cold ones
+func (r *refreshAhead) due(now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0)
+	for key, tracked := range r.keys {
+		if tracked.refreshing {
+			continue
+		}
+
+		hot := tracked.hits >= r.cfg.MinHits
+		tracked.hits /= 2
+
+		if !hot {
+			if tracked.hits == 0 {
+				delete(r.keys, key)
+			}
+			continue
+		}
+
+		if now.Add(r.cfg.Window + tracked.jitter).Before(tracked.freshUntil) {
+			continue
+		}
+
+		tracked.refreshing = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (r *refreshAhead) refresh(ctx context.Context, key string) {
+	ctx, span := telemetry.NewSpan(ctx, "namespace.refresh-ahead")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+	)
+
+	freshUntil, err := r.reload(ctx, key)
+	if err != nil {
+		telemetry.RecordError(span, err)
+	}
+
+	r.done(key, freshUntil)
+}
+
+func (r *refreshAhead) done(key string, freshUntil time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tracked, ok := r.keys[key]
+	if !ok {
+		return
+	}
+
+	tracked.refreshing = false
+	if freshUntil.After(tracked.freshUntil) {
+		tracked.freshUntil = freshUntil
+	}
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache/cachetest"
+)
+
+func TestRefreshAheadDue(t *testing.T) {
+	clock := cachetest.NewFakeClock(time.Now())
+	r := newRefreshAhead(RefreshAheadConfig{Window: 10 * time.Second, MinHits: 2}, time.Minute, clock, nil)
+
+	freshUntil := clock.Now().Add(time.Minute)
+	r.touch("hot", freshUntil)
+	r.touch("hot", freshUntil)
+	r.touch("cold", freshUntil)
+
+	if keys := r.due(clock.Now()); len(keys) != 0 {
+		t.Fatalf("got %v due a minute before they stop being fresh", keys)
+	}
+
+	// hits are halved on every check, the hot key needs to be read again to stay hot
+	r.touch("hot", freshUntil)
+	r.touch("cold", freshUntil)
+
+	now := clock.Advance(55 * time.Second)
+	if keys := r.due(now); !slices.Equal(keys, []string{"hot"}) {
+		t.Fatalf("got %v due within the window, want only the hot key", keys)
+	}
+
+	r.touch("hot", freshUntil)
+	r.touch("hot", freshUntil)
+	if keys := r.due(now); len(keys) != 0 {
+		t.Fatalf("got %v due while the reload is still running", keys)
+	}
+
+	r.done("hot", now.Add(time.Minute))
+	r.touch("hot", now.Add(time.Minute))
+	r.touch("hot", now.Add(time.Minute))
+	if keys := r.due(now); len(keys) != 0 {
+		t.Fatalf("got %v due right after the reload", keys)
+	}
+}
+
+func TestRefreshAheadReloadsOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := cachetest.NewFakeClock(time.Now())
+
+	var reloads atomic.Int32
+	reloaded := make(chan struct{}, 10)
+	r := newRefreshAhead(RefreshAheadConfig{Window: 10 * time.Second, Interval: time.Millisecond}, time.Minute, clock, func(ctx context.Context, key string) (time.Time, error) {
+		reloads.Add(1)
+		reloaded <- struct{}{}
+		return clock.Now().Add(time.Minute), nil
+	})
+
+	r.touch("key", clock.Now().Add(time.Minute))
+	r.touch("key", clock.Now().Add(time.Minute))
+	clock.Advance(55 * time.Second)
+
+	stopped := make(chan struct{})
+	go func() {
+		r.run(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("hot key was not reloaded before it stopped being fresh")
+	}
+
+	// keep the key hot with the value the reload wrote, it is fresh for another minute so it must not be reloaded again
+	for range 20 {
+		r.touch("key", clock.Now().Add(time.Minute))
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-stopped
+
+	if n := reloads.Load(); n != 1 {
+		t.Fatalf("key was reloaded %d times, want 1", n)
+	}
+}
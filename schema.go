@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/goccy/go-json"
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+type SchemaStats struct {
+	// Entries written with another SchemaVersion that were treated as a miss
+	Mismatched uint64
+	// Entries that could not be decoded into T and were treated as a miss
+	Undecodable uint64
+	// Entries that were removed from the store they were found in because of one of the above
+	Removed uint64
+	// Entries of another SchemaVersion that were upgraded with WithMigration and written back
+	Migrated uint64
+}
+
+// schemaGuard treats entries of an older shape of T as misses, so changing T does not fail reads until they expire
+type schemaGuard struct {
+	version int
+	remove  bool
+
+	mismatched  atomic.Uint64
+	undecodable atomic.Uint64
+	removed     atomic.Uint64
+	migrated    atomic.Uint64
+}
+
+func newSchemaGuard(version int, remove bool) *schemaGuard {
+	return &schemaGuard{
+		version: version,
+		remove:  remove,
+	}
+}
+
+// mismatch reports whether the value was written with another schema version
+func (s *schemaGuard) mismatch(v types.TValue) bool {
+	return v.SchemaVersion != s.version
+}
+
+// discard counts entries of the tier that are treated as a miss and removes them if configured
+func (s *schemaGuard) discard(ctx context.Context, ns types.TNamespace, t tier, keys []string, undecodable bool) {
+	if len(keys) == 0 {
+		return
+	}
+
+	if undecodable {
+		s.undecodable.Add(uint64(len(keys)))
+	} else {
+		s.mismatched.Add(uint64(len(keys)))
+	}
+
+	if !s.remove {
+		return
+	}
+
+	ctx, span := telemetry.NewSpan(ctx, t.Store.Name()+".remove-invalid")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "keys", Value: keys},
+		telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+		telemetry.AttributeKV{Key: "tier", Value: t.index},
+		telemetry.AttributeKV{Key: "undecodable", Value: undecodable},
+	)
+
+	// the entry is a miss either way, a failed remove only means it is found again next time
+	if err := t.Store.Remove(ctx, ns, keys); err != nil {
+		telemetry.RecordError(span, err)
+		return
+	}
+
+	s.removed.Add(uint64(len(keys)))
+}
+
+func (s *schemaGuard) stats() SchemaStats {
+	return SchemaStats{
+		Mismatched:  s.mismatched.Load(),
+		Undecodable: s.undecodable.Load(),
+		Removed:     s.removed.Load(),
+		Migrated:    s.migrated.Load(),
+	}
+}
+
+// upgrade is called for entries that can't be decoded or are of another schema version, it returns the migrated
+// entry or false if there is no migration for it and it has to be treated as a miss
+func (t tieredCache[T]) upgrade(ctx context.Context, ns types.TNamespace, tier tier, key string, undecodable bool) (types.TValue, bool) {
+	if value, ok := t.migrate(ctx, ns, tier, key); ok {
+		return value, true
+	}
+
+	t.schema.discard(ctx, ns, tier, []string{key}, undecodable)
+	return types.TValue{}, false
+}
+
+// migrate reads the entry again as raw json and runs the migration registered for its version,
+// the upgraded entry keeps its fresh and stale times and is written back to the tier it came from.
+func (t tieredCache[T]) migrate(ctx context.Context, ns types.TNamespace, tier tier, key string) (types.TValue, bool) {
+	if len(t.migrations) == 0 {
+		return types.TValue{}, false
+	}
+
+	ctx, span := telemetry.NewSpan(ctx, tier.Store.Name()+".migrate")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+		telemetry.AttributeKV{Key: "tier", Value: tier.index},
+	)
+
+	var raw json.RawMessage
+	entry, found, err := tier.Store.Get(ctx, t.ns, key, &raw)
+	if err != nil || !found || entry.Value == nil {
+		telemetry.RecordError(span, err)
+		return types.TValue{}, false
+	}
+
+	migration, ok := t.migrations[entry.SchemaVersion]
+	if !ok || entry.SchemaVersion == t.schema.version {
+		return types.TValue{}, false
+	}
+
+	telemetry.WithAttributes(span, telemetry.AttributeKV{Key: "from_version", Value: entry.SchemaVersion})
+
+	// the memory store keeps values as they are instead of json
+	b, ok := entry.Value.(json.RawMessage)
+	if !ok {
+		if b, err = json.Marshal(entry.Value); err != nil {
+			telemetry.RecordError(span, err)
+			return types.TValue{}, false
+		}
+	}
+
+	value, err := migration(b)
+	if err != nil || value == nil {
+		telemetry.RecordError(span, err)
+		return types.TValue{}, false
+	}
+
+	entry.Key = key
+	entry.Found = true
+	entry.Value = value
+	entry.SchemaVersion = t.schema.version
+
+	if !tier.DisableWrites {
+		// the upgraded value is returned either way, the old entry is just migrated again next time
+		if err := tier.Store.Set(ctx, t.ns, key, entry); err != nil {
+			telemetry.RecordError(span, err)
+		}
+	}
+
+	t.schema.migrated.Add(1)
+
+	return entry, true
+}
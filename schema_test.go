@@ -0,0 +1,128 @@
+package cache_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+type userV1 struct {
+	FullName string
+}
+
+type userV2 struct {
+	First string
+	Last  string
+}
+
+func newVersionedNamespace[T any](store cache.Store, version int, remove bool, opts ...cache.Option[T]) cache.Namespace[T] {
+	return cache.NewNamespace[T]("user", context.Background(), cache.NamespaceConfig{
+		Stores:               []cache.Store{store},
+		Fresh:                time.Minute,
+		Stale:                time.Hour,
+		SchemaVersion:        version,
+		RemoveInvalidEntries: remove,
+	}, opts...)
+}
+
+func TestSchemaVersionMismatch(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(memory.Config{})
+
+	v1 := newVersionedNamespace[userV1](store, 1, false)
+	if err := v1.Set(ctx, "alice", userV1{FullName: "Alice Liddell"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := newVersionedNamespace[user](store, 2, false)
+	if value, found, err := v2.Get(ctx, "alice"); err != nil || found {
+		t.Fatalf("got %v, found %v and %v for an entry of version 1, want a miss", value, found, err)
+	}
+
+	if stats := v2.SchemaStats(); stats.Mismatched != 1 || stats.Removed != 0 {
+		t.Fatalf("got %+v, want one mismatched entry that was kept", stats)
+	}
+
+	if _, found, _ := store.Get(ctx, "user", "alice", &userV1{}); !found {
+		t.Fatal("entry of another version was removed without RemoveInvalidEntries")
+	}
+
+	removing := newVersionedNamespace[user](store, 2, true)
+	if _, found, err := removing.Get(ctx, "alice"); err != nil || found {
+		t.Fatalf("got found %v and %v for an entry of version 1, want a miss", found, err)
+	}
+
+	if stats := removing.SchemaStats(); stats.Mismatched != 1 || stats.Removed != 1 {
+		t.Fatalf("got %+v, want one mismatched entry that was removed", stats)
+	}
+
+	if _, found, _ := store.Get(ctx, "user", "alice", &userV1{}); found {
+		t.Fatal("entry of another version was kept with RemoveInvalidEntries")
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(memory.Config{})
+
+	if err := newVersionedNamespace[userV1](store, 1, false).Set(ctx, "alice", userV1{FullName: "Alice Liddell"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newVersionedNamespace[userV2](store, 2, false).Set(ctx, "bob", userV2{First: "Bob", Last: "Builder"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v3 := newVersionedNamespace(store, 3, false,
+		cache.WithMigration(1, func(raw json.RawMessage) (*user, error) {
+			var old userV1
+			if err := json.Unmarshal(raw, &old); err != nil {
+				return nil, err
+			}
+			return &user{Name: strings.ToLower(strings.Fields(old.FullName)[0])}, nil
+		}),
+		cache.WithMigration(2, func(raw json.RawMessage) (*user, error) {
+			var old userV2
+			if err := json.Unmarshal(raw, &old); err != nil {
+				return nil, err
+			}
+			return &user{Name: strings.ToLower(old.First)}, nil
+		}),
+	)
+
+	values, err := v3.GetMany(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"alice", "bob"} {
+		if !values[i].Found || values[i].Value == nil || values[i].Value.Name != want {
+			t.Fatalf("got %+v for %s, want it upgraded by the migration of its version", values[i], want)
+		}
+	}
+
+	if stats := v3.SchemaStats(); stats.Migrated != 2 || stats.Mismatched != 0 {
+		t.Fatalf("got %+v, want both entries migrated", stats)
+	}
+
+	// the upgraded entries were written back, reading them again does not migrate them again
+	for _, key := range []string{"alice", "bob"} {
+		entry, found, err := store.Get(ctx, "user", key, &user{})
+		if err != nil || !found || entry.SchemaVersion != 3 {
+			t.Fatalf("got version %d, found %v and %v for %s, want the entry written back as version 3", entry.SchemaVersion, found, err, key)
+		}
+
+		if _, found, err := v3.Get(ctx, key); err != nil || !found {
+			t.Fatalf("got found %v and %v for the migrated %s", found, err, key)
+		}
+	}
+
+	if stats := v3.SchemaStats(); stats.Migrated != 2 {
+		t.Fatalf("got %+v, want the written back entries to be read without migrating them again", stats)
+	}
+}
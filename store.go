@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"context"
+
 	"github.com/steamsets/go-cache/pkg/types"
 )
 
@@ -8,9 +10,11 @@ type Store interface {
 	Name() string
 
 	CreateCacheKey(namespace types.TNamespace, key string) string
-	Get(namespace types.TNamespace, key string, T any) (value types.TValue, found bool, err error)
-	GetMany(namespace types.TNamespace, keys []string, T any) ([]types.TValue, error)
-	Set(namespace types.TNamespace, key string, value types.TValue) error
-	SetMany(namespace types.TNamespace, values []types.TValue, opts *types.SetOptions) error
-	Remove(namespace types.TNamespace, key []string) error // This is actuall a removeMany
+	Get(ctx context.Context, namespace types.TNamespace, key string, T any) (value types.TValue, found bool, err error)
+	// GetMany returns one value for every key in the order of keys, with Key set to the key that was asked for
+	// and Found set to false for misses
+	GetMany(ctx context.Context, namespace types.TNamespace, keys []string, T any) ([]types.TValue, error)
+	Set(ctx context.Context, namespace types.TNamespace, key string, value types.TValue) error
+	SetMany(ctx context.Context, namespace types.TNamespace, values []types.TValue, opts *types.SetOptions) error
+	Remove(ctx context.Context, namespace types.TNamespace, key []string) error // This is actuall a removeMany
 }
@@ -1,20 +1,28 @@
 package libsql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/Southclaws/fault"
 	"github.com/Southclaws/fault/fmsg"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/clock"
 	"github.com/steamsets/go-cache/pkg/types"
 )
 
 type LibsqlStore struct {
 	name   string
 	config Config
+	clock  clock.Clock
 }
 
 type Config struct {
@@ -25,6 +33,9 @@ type Config struct {
 
 	// See  SQLITE_LIMIT_VARIABLE_NUMBER
 	MaxPlaceholders int
+
+	// Decides when rows expire, defaults to the system clock
+	Clock clock.Clock
 }
 
 const DefaultTableName = "cache"
@@ -45,6 +56,7 @@ func New(cfg Config) *LibsqlStore {
 	return &LibsqlStore{
 		config: cfg,
 		name:   "libsql",
+		clock:  clock.OrReal(cfg.Clock),
 	}
 }
 
@@ -52,6 +64,27 @@ func (l *LibsqlStore) Name() string {
 	return l.name
 }
 
+func (l *LibsqlStore) Ping(ctx context.Context) error {
+	return l.config.DB.PingContext(ctx)
+}
+
+// Time returns the time of the database, or the time of Config.Clock if one is set
+func (l *LibsqlStore) Time(ctx context.Context) (time.Time, error) {
+	if l.config.Clock != nil {
+		return l.clock.Now(), nil
+	}
+
+	var ms int64
+	err := l.config.DB.
+		QueryRowContext(ctx, "SELECT CAST(ROUND((julianday('now') - 2440587.5) * 86400000) AS INTEGER)").
+		Scan(&ms)
+	if err != nil {
+		return time.Time{}, l.storeError("", "time", nil, err)
+	}
+
+	return time.UnixMilli(ms), nil
+}
+
 func (l *LibsqlStore) CreateCacheKey(namespace types.TNamespace, key string) string {
 	return string(namespace) + "::" + key
 }
@@ -60,7 +93,7 @@ func (l *LibsqlStore) UndoCacheKey(namespace types.TNamespace, key string) strin
 	return strings.TrimPrefix(key, string(namespace)+"::")
 }
 
-func (l *LibsqlStore) Get(ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
+func (l *LibsqlStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
 	cacheKey := l.CreateCacheKey(ns, key)
 	val := types.TValue{Found: false, Key: cacheKey}
 	raw := make([]byte, 0)
@@ -68,30 +101,35 @@ func (l *LibsqlStore) Get(ns types.TNamespace, key string, T any) (value types.T
 	staleUntil := ""
 	freshUntil := ""
 	err = l.config.DB.
-		QueryRow("SELECT key, fresh_until, stale_until, value FROM "+l.config.TableName+" WHERE key = ?", cacheKey).
-		Scan(&val.Key, &freshUntil, &staleUntil, &raw)
+		QueryRowContext(ctx, "SELECT key, fresh_until, stale_until, schema_version, revision, value FROM "+l.config.TableName+" WHERE key = ?", cacheKey).
+		Scan(&val.Key, &freshUntil, &staleUntil, &val.SchemaVersion, &val.Revision, &raw)
 
 	if err == sql.ErrNoRows {
 		return value, false, nil
 	}
 
 	if err != nil {
-		return value, false, err
+		return value, false, l.storeError(ns, "get", []string{key}, err)
 	}
 
 	freshAsTime, err := time.Parse(time.RFC3339, freshUntil)
 	if err != nil {
-		return value, false, err
+		return value, false, cache.NewStoreError(l.name, ns, "get", []string{key}, cache.ErrDecode, err)
 	}
 	staleAsTime, err := time.Parse(time.RFC3339, staleUntil)
 	if err != nil {
-		return value, false, err
+		return value, false, cache.NewStoreError(l.name, ns, "get", []string{key}, cache.ErrDecode, err)
+	}
+
+	// rows are not deleted once they expire, they are just overwritten later
+	if l.clock.Now().After(staleAsTime) {
+		return value, false, nil
 	}
 
 	localT := reflect.New(reflect.TypeOf(T).Elem()).Interface()
 	v, err := types.SetTIntoValue(raw, localT)
 	if err != nil {
-		return value, false, err
+		return value, false, cache.NewStoreError(l.name, ns, "get", []string{key}, cache.ErrDecode, err)
 	}
 
 	val.Key = l.UndoCacheKey(ns, val.Key)
@@ -103,7 +141,7 @@ func (l *LibsqlStore) Get(ns types.TNamespace, key string, T any) (value types.T
 	return val, true, nil
 }
 
-func (l *LibsqlStore) GetMany(ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+func (l *LibsqlStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
 	placeHolders := make([]string, 0)
 	for range keys {
 		placeHolders = append(placeHolders, "?")
@@ -114,14 +152,15 @@ func (l *LibsqlStore) GetMany(ns types.TNamespace, keys []string, T any) ([]type
 		keysToGet = append(keysToGet, l.CreateCacheKey(ns, key))
 	}
 
-	rows, err := l.config.DB.Query("SELECT key, fresh_until, stale_until, value FROM "+l.config.TableName+" WHERE key IN ("+strings.Join(placeHolders, ",")+")", keysToGet...)
+	rows, err := l.config.DB.QueryContext(ctx, "SELECT key, fresh_until, stale_until, schema_version, revision, value FROM "+l.config.TableName+" WHERE key IN ("+strings.Join(placeHolders, ",")+")", keysToGet...)
 	if err != nil {
-		return nil, fault.Wrap(err, fmsg.With("failed to exec query"))
+		return nil, l.storeError(ns, "get-many", keys, fault.Wrap(err, fmsg.With("failed to exec query")))
 	}
 
 	defer rows.Close()
 
-	values := make([]types.TValue, 0)
+	// rows come in any order and without the misses
+	found := make(map[string]types.TValue, len(keys))
 
 	for rows.Next() {
 		val := types.TValue{}
@@ -129,63 +168,221 @@ func (l *LibsqlStore) GetMany(ns types.TNamespace, keys []string, T any) ([]type
 
 		staleUntil := ""
 		freshUntil := ""
-		if err := rows.Scan(&val.Key, &freshUntil, &staleUntil, &raw); err != nil {
-			return nil, fault.Wrap(err, fmsg.With("failed to scan row"))
+		if err := rows.Scan(&val.Key, &freshUntil, &staleUntil, &val.SchemaVersion, &val.Revision, &raw); err != nil {
+			return nil, l.storeError(ns, "get-many", keys, fault.Wrap(err, fmsg.With("failed to scan row")))
 		}
 
+		key := l.UndoCacheKey(ns, val.Key)
 		freshAsTime, err := time.Parse(time.RFC3339, freshUntil)
 		if err != nil {
-			return nil, err
+			return nil, cache.NewStoreError(l.name, ns, "get-many", []string{key}, cache.ErrDecode, err)
 		}
 		staleAsTime, err := time.Parse(time.RFC3339, staleUntil)
 		if err != nil {
-			return nil, err
+			return nil, cache.NewStoreError(l.name, ns, "get-many", []string{key}, cache.ErrDecode, err)
+		}
+
+		if l.clock.Now().After(staleAsTime) {
+			continue
 		}
 
 		localT := reflect.New(reflect.TypeOf(T).Elem()).Interface()
 		v, err := types.SetTIntoValue(raw, localT)
 		if err != nil {
-			return nil, err
+			return nil, cache.NewStoreError(l.name, ns, "get-many", []string{key}, cache.ErrDecode, err)
 		}
 
-		val.Key = l.UndoCacheKey(ns, val.Key)
+		val.Key = key
 		val.Found = true
 		val.FreshUntil = freshAsTime
 		val.StaleUntil = staleAsTime
 		val.Value = v.Value
-		values = append(values, val)
+		found[key] = val
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, l.storeError(ns, "get-many", keys, err)
+	}
+
+	values := make([]types.TValue, 0, len(keys))
+	for _, key := range keys {
+		val, ok := found[key]
+		if !ok {
+			val = types.TValue{Found: false, Key: key}
+		}
+
+		values = append(values, val)
 	}
 
 	return values, nil
 }
 
-func (l *LibsqlStore) Set(ns types.TNamespace, key string, value types.TValue) error {
+func (l *LibsqlStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
 	b, err := json.Marshal(value.Value)
 	if err != nil {
 		return err
 	}
 
-	_, err = l.config.DB.Exec(
-		"INSERT OR REPLACE INTO "+l.config.TableName+" (key, fresh_until, stale_until, value) VALUES(?, ?, ?, ?)",
+	_, err = l.config.DB.ExecContext(
+		ctx,
+		"INSERT OR REPLACE INTO "+l.config.TableName+" (key, fresh_until, stale_until, schema_version, revision, value) VALUES(?, ?, ?, ?, ?, ?)",
 		l.CreateCacheKey(ns, key),
-		value.FreshUntil,
-		value.StaleUntil,
+		formatTime(value.FreshUntil),
+		formatTime(value.StaleUntil),
+		value.SchemaVersion,
+		value.Revision,
 		string(b),
 	)
 
-	return err
+	return l.storeError(ns, "set", []string{key}, err)
+}
+
+// SetIfAbsent replaces expired rows, as they are not deleted once they expire.
+// A row that is still alive is not changed, which shows as no affected rows
+func (l *LibsqlStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	b, err := json.Marshal(value.Value)
+	if err != nil {
+		return err
+	}
+
+	res, err := l.config.DB.ExecContext(
+		ctx,
+		"INSERT INTO "+l.config.TableName+" (key, fresh_until, stale_until, schema_version, revision, value) VALUES(?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT(key) DO UPDATE SET "+
+			"fresh_until = excluded.fresh_until, stale_until = excluded.stale_until, schema_version = excluded.schema_version, "+
+			"revision = excluded.revision, value = excluded.value "+
+			"WHERE stale_until < ?",
+		l.CreateCacheKey(ns, key),
+		formatTime(value.FreshUntil),
+		formatTime(value.StaleUntil),
+		value.SchemaVersion,
+		value.Revision,
+		string(b),
+		formatTime(l.clock.Now()),
+	)
+
+	return l.conditional(ns, "set-if-absent", key, res, err)
+}
+
+// CompareAndSwap only replaces rows that did not expire yet, an expired row is a miss like in Get
+func (l *LibsqlStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	b, err := json.Marshal(value.Value)
+	if err != nil {
+		return err
+	}
+
+	res, err := l.config.DB.ExecContext(
+		ctx,
+		"UPDATE "+l.config.TableName+" SET fresh_until = ?, stale_until = ?, schema_version = ?, revision = ?, value = ? "+
+			"WHERE key = ? AND revision = ? AND stale_until >= ?",
+		formatTime(value.FreshUntil),
+		formatTime(value.StaleUntil),
+		value.SchemaVersion,
+		value.Revision,
+		string(b),
+		l.CreateCacheKey(ns, key),
+		expected,
+		formatTime(l.clock.Now()),
+	)
+
+	return l.conditional(ns, "compare-and-swap", key, res, err)
+}
+
+// Increment keeps the counter as a number in the value column, an expired counter starts over
+func (l *LibsqlStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	now := l.clock.Now().UTC()
+
+	staleUntil := now.Add(ttl)
+	if ttl <= 0 {
+		staleUntil = neverExpires
+	}
+
+	expiry := formatTime(staleUntil)
+	current := formatTime(now)
+
+	var value int64
+	err := l.config.DB.QueryRowContext(
+		ctx,
+		"INSERT INTO "+l.config.TableName+" (key, fresh_until, stale_until, value) VALUES(?, ?, ?, ?) "+
+			"ON CONFLICT(key) DO UPDATE SET "+
+			"value = CASE WHEN stale_until < ? THEN excluded.value ELSE value + excluded.value END, "+
+			"fresh_until = CASE WHEN stale_until < ? THEN excluded.fresh_until ELSE fresh_until END, "+
+			"stale_until = CASE WHEN stale_until < ? THEN excluded.stale_until ELSE stale_until END "+
+			"RETURNING value",
+		l.CreateCacheKey(ns, key),
+		expiry,
+		expiry,
+		delta,
+		current,
+		current,
+		current,
+	).Scan(&value)
+	if err != nil {
+		return 0, l.storeError(ns, "increment", []string{key}, err)
+	}
+
+	return value, nil
+}
+
+func (l *LibsqlStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	var value int64
+	staleUntil := ""
+
+	err := l.config.DB.
+		QueryRowContext(ctx, "SELECT stale_until, value FROM "+l.config.TableName+" WHERE key = ?", l.CreateCacheKey(ns, key)).
+		Scan(&staleUntil, &value)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, l.storeError(ns, "counter", []string{key}, err)
+	}
+
+	staleAsTime, err := time.Parse(time.RFC3339, staleUntil)
+	if err != nil {
+		return 0, false, cache.NewStoreError(l.name, ns, "counter", []string{key}, cache.ErrDecode, err)
+	}
+
+	if l.clock.Now().After(staleAsTime) {
+		return 0, false, nil
+	}
+
+	return value, true, nil
+}
+
+// formatTime writes times as RFC3339 text in UTC, so rows can be compared with the current time in SQL
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// neverExpires is used as the stale time of counters without a ttl
+var neverExpires = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// conditional turns a conditional write that did not change any row into cache.ErrConflict
+func (l *LibsqlStore) conditional(ns types.TNamespace, op string, key string, res sql.Result, err error) error {
+	if err != nil {
+		return l.storeError(ns, op, []string{key}, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return l.storeError(ns, op, []string{key}, err)
+	}
+
+	if affected == 0 {
+		return cache.NewStoreError(l.name, ns, op, []string{key}, cache.ErrConflict, cache.ErrConflict)
+	}
+
+	return nil
 }
 
 // Amount of rows we are using
-const placeHoldersPerRow = 4
+const placeHoldersPerRow = 6
 
-func (l *LibsqlStore) SetMany(ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+func (l *LibsqlStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
 	// IMPORTANT: This is not a transaction and will be a max of maxPlaceholders placeholders at a time
-	// cache table has 4 columns so we need to multiply by placeHoldersPerRow
+	// cache table has 6 columns so we need to multiply by placeHoldersPerRow
 	totalPlaceholders := placeHoldersPerRow * len(values)
 
 	chunks := make([][]types.TValue, 0)
@@ -213,7 +410,7 @@ func (l *LibsqlStore) SetMany(ns types.TNamespace, values []types.TValue, opts *
 	}
 
 	for _, chunk := range chunks {
-		sql := "INSERT OR REPLACE INTO " + l.config.TableName + " (key, fresh_until, stale_until, value) VALUES "
+		sql := "INSERT OR REPLACE INTO " + l.config.TableName + " (key, fresh_until, stale_until, schema_version, revision, value) VALUES "
 		params := make([]interface{}, 0)
 		for _, v := range chunk {
 			b, err := json.Marshal(v.Value)
@@ -221,22 +418,27 @@ func (l *LibsqlStore) SetMany(ns types.TNamespace, values []types.TValue, opts *
 				return err
 			}
 
-			sql = sql + "(?, ?, ?, ?),"
-			params = append(params, l.CreateCacheKey(ns, v.Key), v.FreshUntil, v.StaleUntil, string(b))
+			sql = sql + "(?, ?, ?, ?, ?, ?),"
+			params = append(params, l.CreateCacheKey(ns, v.Key), formatTime(v.FreshUntil), formatTime(v.StaleUntil), v.SchemaVersion, v.Revision, string(b))
 		}
 
 		sql = sql[:len(sql)-1]
 
-		_, err := l.config.DB.Exec(sql, params...)
+		_, err := l.config.DB.ExecContext(ctx, sql, params...)
 		if err != nil {
-			return err
+			keys := make([]string, 0, len(chunk))
+			for _, v := range chunk {
+				keys = append(keys, v.Key)
+			}
+
+			return l.storeError(ns, "set-many", keys, err)
 		}
 	}
 
 	return nil
 }
 
-func (l *LibsqlStore) Remove(ns types.TNamespace, key []string) error {
+func (l *LibsqlStore) Remove(ctx context.Context, ns types.TNamespace, key []string) error {
 	placeHolders := make([]string, 0)
 	for range key {
 		placeHolders = append(placeHolders, "?")
@@ -248,6 +450,49 @@ func (l *LibsqlStore) Remove(ns types.TNamespace, key []string) error {
 		keysToDelete = append(keysToDelete, l.CreateCacheKey(ns, key))
 	}
 
-	_, err := l.config.DB.Exec("DELETE FROM "+l.config.TableName+" WHERE key IN ("+strings.Join(placeHolders, ",")+")", keysToDelete...)
-	return err
+	_, err := l.config.DB.ExecContext(ctx, "DELETE FROM "+l.config.TableName+" WHERE key IN ("+strings.Join(placeHolders, ",")+")", keysToDelete...)
+	return l.storeError(ns, "remove", key, err)
+}
+
+// storeError wraps err with the store, namespace and keys it failed for, dropped connections are reported as cache.ErrStoreUnavailable
+func (l *LibsqlStore) storeError(ns types.TNamespace, op string, keys []string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var kind error
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		kind = cache.ErrTimeout
+	} else if errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, io.EOF) {
+		kind = cache.ErrStoreUnavailable
+	}
+
+	return cache.NewStoreError(l.name, ns, op, keys, kind, err)
+}
+
+// IsRetryable reports whether err is a transient error, e.g. a dropped connection or a locked database,
+// that is worth retrying. It can be used as the classifier of the retry middleware.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	// SQLITE_BUSY and SQLITE_LOCKED are only exposed as messages by most drivers
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked") || strings.Contains(msg, "sqlite_busy")
 }
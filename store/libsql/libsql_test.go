@@ -0,0 +1,113 @@
+package libsql_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/cachetest"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/libsql"
+	"github.com/steamsets/go-cache/storetest"
+	_ "modernc.org/sqlite"
+)
+
+// the table from the README
+const schema = `CREATE TABLE cache
+(
+    key TEXT PRIMARY KEY,
+    fresh_until INTEGER,
+    stale_until INTEGER,
+    schema_version INTEGER NOT NULL DEFAULT 0,
+    revision INTEGER NOT NULL DEFAULT 0,
+    value       TEXT
+)`
+
+// newStore returns a store on a new SQLite file in t.TempDir()
+func newStore(t *testing.T, clock cache.Clock) *libsql.LibsqlStore {
+	t.Helper()
+
+	// concurrent writers wait for the lock instead of failing right away, like they would with a libsql server
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "cache.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	return libsql.New(libsql.Config{DB: db, Clock: clock})
+}
+
+func TestConformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) cache.Store {
+		return newStore(t, nil)
+	})
+}
+
+func TestConditionalWritesExpiredRows(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	store := newStore(t, clock)
+
+	value := func(name string, revision uint64) types.TValue {
+		now := clock.Now()
+		return types.TValue{
+			Value:      name,
+			FreshUntil: now.Add(time.Minute),
+			StaleUntil: now.Add(time.Hour),
+			Revision:   revision,
+		}
+	}
+
+	if err := store.SetIfAbsent(ctx, "ns", "key", value("first", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetIfAbsent(ctx, "ns", "key", value("second", 1)); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("SetIfAbsent over a live row got %v, want ErrConflict", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+
+	if err := store.CompareAndSwap(ctx, "ns", "key", 1, value("swapped", 2)); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("CompareAndSwap of an expired row got %v, want ErrConflict", err)
+	}
+
+	if err := store.SetIfAbsent(ctx, "ns", "key", value("third", 1)); err != nil {
+		t.Fatalf("SetIfAbsent over an expired row got %v", err)
+	}
+
+	var name string
+	got, found, err := store.Get(ctx, "ns", "key", &name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found || got.Value != "third" {
+		t.Fatalf("got %v, found %v, want the value written over the expired row", got.Value, found)
+	}
+
+	if err := store.CompareAndSwap(ctx, "ns", "key", 1, value("swapped", 2)); err != nil {
+		t.Fatalf("CompareAndSwap of a live row got %v", err)
+	}
+}
+
+func TestTime(t *testing.T) {
+	store := newStore(t, nil)
+
+	now, err := store.Time(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(now); d < -time.Second || d > time.Second {
+		t.Fatalf("database time is %v off", d)
+	}
+}
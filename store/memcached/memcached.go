@@ -1,26 +1,40 @@
 package memcached
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/clock"
 	"github.com/steamsets/go-cache/pkg/types"
 )
 
 type MemcachedStore struct {
 	name   string
 	config Config
+	clock  clock.Clock
 }
 
 type Config struct {
 	Client *memcache.Client
+	// Decides when counters expire, defaults to the system clock. Values expire at their StaleUntil
+	Clock clock.Clock
 }
 
 func New(cfg Config) *MemcachedStore {
 	return &MemcachedStore{
 		config: cfg,
 		name:   "memcache",
+		clock:  clock.OrReal(cfg.Clock),
 	}
 }
 
@@ -28,45 +42,51 @@ func (m *MemcachedStore) Name() string {
 	return m.name
 }
 
+// Ping does not support a context, gomemcache uses the timeout of the client instead
+func (m *MemcachedStore) Ping(ctx context.Context) error {
+	return m.config.Client.Ping()
+}
+
 func (m *MemcachedStore) CreateCacheKey(namespace types.TNamespace, key string) string {
 	return string(namespace) + "::" + key
 }
 
-func (m *MemcachedStore) Get(ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
+func (m *MemcachedStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
 	item, err := m.config.Client.Get(m.CreateCacheKey(ns, key))
 	if err == memcache.ErrCacheMiss {
 		return value, false, nil
 	}
 
 	if err != nil {
-		return value, false, err
+		return value, false, m.storeError(ns, "get", []string{key}, err)
 	}
 
 	v, err := types.SetTIntoTValue(item.Value, T)
 	if err != nil {
-		return value, true, err
+		return value, true, cache.NewStoreError(m.name, ns, "get", []string{key}, cache.ErrDecode, err)
 	}
 
 	value = *v
 	return value, true, nil
 }
 
-func (m *MemcachedStore) GetMany(ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
-	keysToGet := make([]string, 0)
+func (m *MemcachedStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	keysToGet := make([]string, 0, len(keys))
 	for _, k := range keys {
 		keysToGet = append(keysToGet, m.CreateCacheKey(ns, k))
 	}
 
 	items, err := m.config.Client.GetMulti(keysToGet)
 	if err != nil {
-		return nil, err
+		return nil, m.storeError(ns, "get-many", keys, err)
 	}
 
-	values := make([]types.TValue, 0)
+	values := make([]types.TValue, 0, len(keys))
 
-	for key, value := range items {
-		// I just assume this means not found
-		if value == nil {
+	for i, key := range keys {
+		// misses are not part of the result
+		item, ok := items[keysToGet[i]]
+		if !ok || item == nil {
 			values = append(values, types.TValue{
 				Found: false,
 				Value: nil,
@@ -77,11 +97,12 @@ func (m *MemcachedStore) GetMany(ns types.TNamespace, keys []string, T any) ([]t
 
 		localT := reflect.New(reflect.TypeOf(T).Elem()).Interface()
 
-		v, err := types.SetTIntoTValue(value.Value, localT)
+		v, err := types.SetTIntoTValue(item.Value, localT)
 		if err != nil {
-			return nil, err
+			return nil, cache.NewStoreError(m.name, ns, "get-many", []string{key}, cache.ErrDecode, err)
 		}
 
+		v.Key = key
 		v.Found = true
 		values = append(values, *v)
 	}
@@ -89,20 +110,20 @@ func (m *MemcachedStore) GetMany(ns types.TNamespace, keys []string, T any) ([]t
 	return values, nil
 }
 
-func (m *MemcachedStore) Set(ns types.TNamespace, key string, value types.TValue) error {
+func (m *MemcachedStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
 	b, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	return m.config.Client.Set(&memcache.Item{
+	return m.storeError(ns, "set", []string{key}, m.config.Client.Set(&memcache.Item{
 		Expiration: int32(value.StaleUntil.Unix()),
 		Key:        m.CreateCacheKey(ns, key),
 		Value:      b,
-	})
+	}))
 }
 
-func (m *MemcachedStore) SetMany(ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+func (m *MemcachedStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
 	for _, v := range values {
 		b, err := json.Marshal(v)
 
@@ -115,24 +136,203 @@ func (m *MemcachedStore) SetMany(ns types.TNamespace, values []types.TValue, opt
 			Key:        m.CreateCacheKey(ns, v.Key),
 			Value:      b,
 		}); err != nil {
-			return err
+			return m.storeError(ns, "set-many", []string{v.Key}, err)
 		}
 	}
 
 	return nil
 }
 
-func (m *MemcachedStore) Remove(ns types.TNamespace, keys []string) error {
-	keysToRemove := make([]string, 0)
-	for _, k := range keys {
-		keysToRemove = append(keysToRemove, m.CreateCacheKey(ns, k))
+func (m *MemcachedStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
 	}
 
-	for _, key := range keysToRemove {
-		if err := m.config.Client.Delete(key); err != nil && err != memcache.ErrCacheMiss {
-			return err
+	err = m.config.Client.Add(&memcache.Item{
+		Expiration: int32(value.StaleUntil.Unix()),
+		Key:        m.CreateCacheKey(ns, key),
+		Value:      b,
+	})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return cache.NewStoreError(m.name, ns, "set-if-absent", []string{key}, cache.ErrConflict, err)
+	}
+
+	return m.storeError(ns, "set-if-absent", []string{key}, err)
+}
+
+func (m *MemcachedStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	item, err := m.config.Client.Get(m.CreateCacheKey(ns, key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return cache.NewStoreError(m.name, ns, "compare-and-swap", []string{key}, cache.ErrConflict, err)
+	}
+
+	if err != nil {
+		return m.storeError(ns, "compare-and-swap", []string{key}, err)
+	}
+
+	// only the revision is needed, the value itself is kept as json
+	var current struct {
+		Revision uint64
+	}
+	if err := json.Unmarshal(item.Value, &current); err != nil {
+		return cache.NewStoreError(m.name, ns, "compare-and-swap", []string{key}, cache.ErrDecode, err)
+	}
+
+	if current.Revision != expected {
+		return cache.NewStoreError(m.name, ns, "compare-and-swap", []string{key}, cache.ErrConflict, cache.ErrConflict)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// the item keeps the cas id of the Get, so a write in between makes this fail
+	item.Value = b
+	item.Expiration = int32(value.StaleUntil.Unix())
+
+	err = m.config.Client.CompareAndSwap(item)
+	if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) {
+		return cache.NewStoreError(m.name, ns, "compare-and-swap", []string{key}, cache.ErrConflict, err)
+	}
+
+	return m.storeError(ns, "compare-and-swap", []string{key}, err)
+}
+
+// Increment uses incr and decr, memcached counters are unsigned so they stop at 0
+func (m *MemcachedStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	k := m.CreateCacheKey(ns, key)
+
+	// a counter that was created by someone else in between is incremented on the next round
+	for attempt := 0; attempt < 3; attempt++ {
+		value, err := m.incr(k, delta)
+		if err == nil {
+			return int64(value), nil
+		}
+
+		if !errors.Is(err, memcache.ErrCacheMiss) {
+			return 0, m.storeError(ns, "increment", []string{key}, err)
+		}
+
+		initial := delta
+		if initial < 0 {
+			initial = 0
+		}
+
+		var expiration int32
+		if ttl > 0 {
+			expiration = int32(m.clock.Now().Add(ttl).Unix())
+		}
+
+		err = m.config.Client.Add(&memcache.Item{
+			Key:        k,
+			Value:      []byte(strconv.FormatInt(initial, 10)),
+			Expiration: expiration,
+		})
+		if err == nil {
+			return initial, nil
+		}
+
+		if !errors.Is(err, memcache.ErrNotStored) {
+			return 0, m.storeError(ns, "increment", []string{key}, err)
+		}
+	}
+
+	return 0, cache.NewStoreError(m.name, ns, "increment", []string{key}, cache.ErrConflict, cache.ErrConflict)
+}
+
+func (m *MemcachedStore) incr(key string, delta int64) (uint64, error) {
+	if delta < 0 {
+		return m.config.Client.Decrement(key, uint64(-delta))
+	}
+
+	return m.config.Client.Increment(key, uint64(delta))
+}
+
+func (m *MemcachedStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	item, err := m.config.Client.Get(m.CreateCacheKey(ns, key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, m.storeError(ns, "counter", []string{key}, err)
+	}
+
+	// incr pads the value with spaces when it gets shorter
+	value, err := strconv.ParseInt(strings.TrimSpace(string(item.Value)), 10, 64)
+	if err != nil {
+		return 0, false, cache.NewStoreError(m.name, ns, "counter", []string{key}, cache.ErrDecode, err)
+	}
+
+	return value, true, nil
+}
+
+func (m *MemcachedStore) Remove(ctx context.Context, ns types.TNamespace, keys []string) error {
+	for _, key := range keys {
+		if err := m.config.Client.Delete(m.CreateCacheKey(ns, key)); err != nil && err != memcache.ErrCacheMiss {
+			return m.storeError(ns, "remove", []string{key}, err)
 		}
 	}
 
 	return nil
 }
+
+// storeError wraps err with the store, namespace and keys it failed for, unreachable servers are reported as cache.ErrStoreUnavailable
+func (m *MemcachedStore) storeError(ns types.TNamespace, op string, keys []string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var kind error
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		kind = cache.ErrTimeout
+	case errors.As(err, &netErr),
+		errors.Is(err, memcache.ErrNoServers),
+		errors.Is(err, io.EOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED):
+		kind = cache.ErrStoreUnavailable
+	}
+
+	return cache.NewStoreError(m.name, ns, op, keys, kind, err)
+}
+
+// IsRetryable reports whether err is a transient error, e.g. a connection reset or a server error,
+// that is worth retrying. It can be used as the classifier of the retry middleware.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch {
+	case errors.Is(err, memcache.ErrCacheMiss),
+		errors.Is(err, memcache.ErrCASConflict),
+		errors.Is(err, memcache.ErrNotStored),
+		errors.Is(err, memcache.ErrMalformedKey),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+
+	var timeoutErr *memcache.ConnectTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, memcache.ErrServerError) ||
+		errors.Is(err, memcache.ErrNoServers) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,128 @@
+package memcached_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/cachetest"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memcached"
+	"github.com/steamsets/go-cache/storetest"
+)
+
+// newStore returns a store on the server of MEMCACHED_ADDR, or on an in-process stand-in if it is not set
+func newStore(t *testing.T) *memcached.MemcachedStore {
+	t.Helper()
+
+	addr := os.Getenv("MEMCACHED_ADDR")
+	if addr == "" {
+		addr = newServer(t, time.Now)
+	}
+
+	return memcached.New(memcached.Config{Client: memcache.New(addr)})
+}
+
+func TestConformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) cache.Store {
+		return newStore(t)
+	})
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	ns := types.TNamespace(t.Name())
+
+	value := func(v string, revision uint64) types.TValue {
+		return types.TValue{Key: "key", Value: v, StaleUntil: time.Now().Add(time.Hour), Revision: revision}
+	}
+
+	if err := store.CompareAndSwap(ctx, ns, "key", 0, value("swapped", 1)); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("CompareAndSwap of a missing key got %v, want ErrConflict", err)
+	}
+
+	if err := store.SetIfAbsent(ctx, ns, "key", value("first", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetIfAbsent(ctx, ns, "key", value("second", 1)); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("SetIfAbsent of an existing key got %v, want ErrConflict", err)
+	}
+
+	if err := store.CompareAndSwap(ctx, ns, "key", 2, value("swapped", 3)); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("CompareAndSwap with another revision got %v, want ErrConflict", err)
+	}
+
+	if err := store.CompareAndSwap(ctx, ns, "key", 1, value("swapped", 2)); err != nil {
+		t.Fatalf("CompareAndSwap with the current revision got %v", err)
+	}
+
+	var got string
+	current, found, err := store.Get(ctx, ns, "key", &got)
+	if err != nil || !found {
+		t.Fatalf("got found %v and %v, want the swapped value", found, err)
+	}
+
+	if current.Revision != 2 || current.Value != "swapped" {
+		t.Fatalf("got revision %d and %v, want the swapped value", current.Revision, current.Value)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	ns := types.TNamespace(t.Name())
+
+	if _, found, err := store.Counter(ctx, ns, "views"); err != nil || found {
+		t.Fatalf("got found %v and %v for a missing counter", found, err)
+	}
+
+	if value, err := store.Increment(ctx, ns, "views", 5, time.Minute); err != nil || value != 5 {
+		t.Fatalf("got %d and %v, want the counter to be created with 5", value, err)
+	}
+
+	if value, err := store.Increment(ctx, ns, "views", 7, time.Minute); err != nil || value != 12 {
+		t.Fatalf("got %d and %v, want 12", value, err)
+	}
+
+	if value, err := store.Increment(ctx, ns, "views", -2, time.Minute); err != nil || value != 10 {
+		t.Fatalf("got %d and %v, want 10", value, err)
+	}
+
+	// memcached counters are unsigned
+	if value, err := store.Increment(ctx, ns, "views", -20, time.Minute); err != nil || value != 0 {
+		t.Fatalf("got %d and %v, want the counter to stop at 0", value, err)
+	}
+
+	if value, found, err := store.Counter(ctx, ns, "views"); err != nil || !found || value != 0 {
+		t.Fatalf("got %d, found %v and %v, want 0", value, found, err)
+	}
+}
+
+func TestIncrementClock(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now().Add(-24 * time.Hour))
+	store := memcached.New(memcached.Config{
+		Client: memcache.New(newServer(t, clock.Now)),
+		Clock:  clock,
+	})
+
+	if _, err := store.Increment(ctx, "ns", "views", 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, found, err := store.Counter(ctx, "ns", "views"); err != nil || !found {
+		t.Fatalf("got found %v and %v within the ttl, want the counter", found, err)
+	}
+
+	clock.Advance(time.Minute)
+	if _, found, err := store.Counter(ctx, "ns", "views"); err != nil || found {
+		t.Fatalf("got found %v and %v after the ttl, want the counter to be gone", found, err)
+	}
+}
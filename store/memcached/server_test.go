@@ -0,0 +1,241 @@
+package memcached_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server is an in-process stand-in for memcached. It speaks the text protocol as far as gomemcache uses it
+// for this store: version, gets, set, add, cas, delete, incr, decr and touch
+type server struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	items map[string]item
+	cas   uint64
+}
+
+type item struct {
+	flags uint32
+	data  []byte
+	cas   uint64
+	// zero if the item does not expire
+	expires time.Time
+}
+
+// newServer starts a server that expires items by now and is stopped with the test, it returns its address
+func newServer(t *testing.T, now func() time.Time) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{now: now, items: make(map[string]item)}
+
+	wg := sync.WaitGroup{}
+	conns := make(map[net.Conn]struct{})
+	connsMu := sync.Mutex{}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			connsMu.Lock()
+			conns[conn] = struct{}{}
+			connsMu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		l.Close()
+		connsMu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		connsMu.Unlock()
+		wg.Wait()
+	})
+
+	return l.Addr().String()
+}
+
+func (s *server) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		if !s.handle(fields, r, w) || w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// handle answers one command, it returns false if the connection has to be closed
+func (s *server) handle(fields []string, r *bufio.Reader, w *bufio.Writer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd := fields[0]; cmd {
+	case "version":
+		fmt.Fprint(w, "VERSION stand-in\r\n")
+	case "get", "gets":
+		for _, key := range fields[1:] {
+			it, ok := s.get(key)
+			if !ok {
+				continue
+			}
+
+			fmt.Fprintf(w, "VALUE %s %d %d %d\r\n%s\r\n", key, it.flags, len(it.data), it.cas, it.data)
+		}
+		fmt.Fprint(w, "END\r\n")
+	case "set", "add", "cas":
+		// <cmd> <key> <flags> <exptime> <bytes> [<cas unique>]
+		if len(fields) < 5 {
+			return false
+		}
+
+		flags, _ := strconv.ParseUint(fields[2], 10, 32)
+		exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+		size, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return false
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return false
+		}
+
+		key := fields[1]
+		current, exists := s.get(key)
+
+		switch {
+		case cmd == "add" && exists:
+			fmt.Fprint(w, "NOT_STORED\r\n")
+			return true
+		case cmd == "cas" && !exists:
+			fmt.Fprint(w, "NOT_FOUND\r\n")
+			return true
+		case cmd == "cas" && (len(fields) < 6 || fields[5] != strconv.FormatUint(current.cas, 10)):
+			fmt.Fprint(w, "EXISTS\r\n")
+			return true
+		}
+
+		s.cas++
+		s.items[key] = item{flags: uint32(flags), data: data[:size], cas: s.cas, expires: s.expires(exptime)}
+		fmt.Fprint(w, "STORED\r\n")
+	case "delete":
+		if _, ok := s.get(fields[1]); !ok {
+			fmt.Fprint(w, "NOT_FOUND\r\n")
+			return true
+		}
+
+		delete(s.items, fields[1])
+		fmt.Fprint(w, "DELETED\r\n")
+	case "incr", "decr":
+		it, ok := s.get(fields[1])
+		if !ok {
+			fmt.Fprint(w, "NOT_FOUND\r\n")
+			return true
+		}
+
+		delta, _ := strconv.ParseUint(fields[2], 10, 64)
+		value, err := strconv.ParseUint(strings.TrimSpace(string(it.data)), 10, 64)
+		if err != nil {
+			fmt.Fprint(w, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+			return true
+		}
+
+		// counters are unsigned, decr stops at 0 and incr wraps around
+		switch {
+		case cmd == "incr":
+			value += delta
+		case delta > value:
+			value = 0
+		default:
+			value -= delta
+		}
+
+		s.cas++
+		it.data = []byte(strconv.FormatUint(value, 10))
+		it.cas = s.cas
+		s.items[fields[1]] = it
+		fmt.Fprintf(w, "%d\r\n", value)
+	case "touch":
+		it, ok := s.get(fields[1])
+		if !ok {
+			fmt.Fprint(w, "NOT_FOUND\r\n")
+			return true
+		}
+
+		exptime, _ := strconv.ParseInt(fields[2], 10, 64)
+		it.expires = s.expires(exptime)
+		s.items[fields[1]] = it
+		fmt.Fprint(w, "TOUCHED\r\n")
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+
+	return true
+}
+
+// get has to be called with the lock held, it removes the item if it expired
+func (s *server) get(key string) (item, bool) {
+	it, ok := s.items[key]
+	if !ok {
+		return item{}, false
+	}
+
+	if !it.expires.IsZero() && !s.now().Before(it.expires) {
+		delete(s.items, key)
+		return item{}, false
+	}
+
+	return it, true
+}
+
+// expires turns an exptime into a time like memcached does: 0 never expires, up to 30 days is relative
+// and anything above is a unix timestamp, negative ones have expired already
+func (s *server) expires(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return s.now()
+	case exptime <= 60*60*24*30:
+		return s.now().Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
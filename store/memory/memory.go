@@ -1,10 +1,16 @@
 package memory
 
 import (
+	"context"
+	"hash/fnv"
 	"math/rand/v2"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/maypok86/otter"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/clock"
 	"github.com/steamsets/go-cache/pkg/types"
 )
 
@@ -16,12 +22,17 @@ type UnstableEvictOnSetConfig struct {
 type Config struct {
 	MaxSize            int
 	UnstableEvictOnSet *UnstableEvictOnSetConfig
+	// Decides when values expire, defaults to the system clock
+	Clock clock.Clock
 }
 
 type MemoryStore struct {
 	name   string
 	config Config
 	otter  *otter.Cache[string, types.TValue]
+	// otter has no compute, so writes to the same key are serialized by one of these for conditional writes
+	locks [64]sync.Mutex
+	clock clock.Clock
 }
 
 func New(cfg Config) *MemoryStore {
@@ -43,6 +54,7 @@ func New(cfg Config) *MemoryStore {
 		name:   "memory",
 		otter:  &otter,
 		config: cfg,
+		clock:  clock.OrReal(cfg.Clock),
 	}
 }
 
@@ -50,11 +62,16 @@ func (m *MemoryStore) Name() string {
 	return m.name
 }
 
+// Time returns the time of Config.Clock, which decides when values expire
+func (m *MemoryStore) Time(ctx context.Context) (time.Time, error) {
+	return m.clock.Now(), nil
+}
+
 func (m *MemoryStore) CreateCacheKey(namespace types.TNamespace, key string) string {
 	return string(namespace) + "::" + key
 }
 
-func (m *MemoryStore) Get(ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
+func (m *MemoryStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
 	k := m.CreateCacheKey(ns, key)
 
 	value, found = m.otter.Get(k)
@@ -63,28 +80,32 @@ func (m *MemoryStore) Get(ns types.TNamespace, key string, T any) (value types.T
 		return value, false, nil
 	}
 
-	if time.Now().After(value.StaleUntil) {
-		m.Remove(ns, []string{key})
+	// remote stores let values expire at StaleUntil, so should this one
+	if m.clock.Now().After(value.StaleUntil) {
+		m.Remove(ctx, ns, []string{key})
+		return types.TValue{}, false, nil
 	}
 
 	return value, true, nil
 }
 
-func (m *MemoryStore) GetMany(ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
-	values := make([]types.TValue, 0)
+func (m *MemoryStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	values := make([]types.TValue, 0, len(keys))
+	now := m.clock.Now()
 
 	for _, k := range keys {
 		value, found := m.otter.Get(m.CreateCacheKey(ns, k))
 
-		if !found {
-			value = types.TValue{
+		if !found || now.After(value.StaleUntil) {
+			values = append(values, types.TValue{
 				Found: false,
 				Value: nil,
 				Key:   k,
-			}
+			})
 			continue
 		}
 
+		value.Key = k
 		value.Found = true
 		values = append(values, value)
 	}
@@ -92,12 +113,16 @@ func (m *MemoryStore) GetMany(ns types.TNamespace, keys []string, T any) ([]type
 	return values, nil
 }
 
-func (m *MemoryStore) Set(ns types.TNamespace, key string, value types.TValue) error {
+func (m *MemoryStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
 	k := m.CreateCacheKey(ns, key)
+
+	lock := m.lock(k)
+	lock.Lock()
 	m.otter.Set(k, value)
+	lock.Unlock()
 
 	if m.config.UnstableEvictOnSet != nil && rand.Float64() > m.config.UnstableEvictOnSet.Frequency {
-		now := time.Now()
+		now := m.clock.Now()
 		m.otter.Range(func(key string, value types.TValue) bool {
 			if now.After(value.StaleUntil) {
 				m.otter.Delete(key)
@@ -120,10 +145,99 @@ func (m *MemoryStore) Set(ns types.TNamespace, key string, value types.TValue) e
 	return nil
 }
 
+func (m *MemoryStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	k := m.CreateCacheKey(ns, key)
+
+	lock := m.lock(k)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if current, found := m.otter.Get(k); found && m.clock.Now().Before(current.StaleUntil) {
+		return cache.NewStoreError(m.name, ns, "set-if-absent", []string{key}, cache.ErrConflict, cache.ErrConflict)
+	}
+
+	m.otter.Set(k, value)
+	return nil
+}
+
+func (m *MemoryStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	k := m.CreateCacheKey(ns, key)
+
+	lock := m.lock(k)
+	lock.Lock()
+	defer lock.Unlock()
+
+	current, found := m.otter.Get(k)
+	if !found || m.clock.Now().After(current.StaleUntil) || current.Revision != expected {
+		return cache.NewStoreError(m.name, ns, "compare-and-swap", []string{key}, cache.ErrConflict, cache.ErrConflict)
+	}
+
+	m.otter.Set(k, value)
+	return nil
+}
+
+// Increment adds to the counter without a lock, only creating the counter takes the lock of its key
+func (m *MemoryStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	k := m.CreateCacheKey(ns, key)
+
+	if counter, ok := m.counter(k); ok {
+		return counter.Add(delta), nil
+	}
+
+	lock := m.lock(k)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if counter, ok := m.counter(k); ok {
+		return counter.Add(delta), nil
+	}
+
+	counter := &atomic.Int64{}
+	counter.Store(delta)
+
+	staleUntil := m.clock.Now().Add(ttl)
+	if ttl <= 0 {
+		staleUntil = neverExpires
+	}
+
+	m.otter.Set(k, types.TValue{Key: key, Value: counter, FreshUntil: staleUntil, StaleUntil: staleUntil})
+
+	return delta, nil
+}
+
+func (m *MemoryStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	counter, ok := m.counter(m.CreateCacheKey(ns, key))
+	if !ok {
+		return 0, false, nil
+	}
+
+	return counter.Load(), true, nil
+}
+
+// neverExpires is used as the stale time of counters without a ttl
+var neverExpires = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+func (m *MemoryStore) counter(key string) (*atomic.Int64, bool) {
+	value, found := m.otter.Get(key)
+	if !found || m.clock.Now().After(value.StaleUntil) {
+		return nil, false
+	}
+
+	counter, ok := value.Value.(*atomic.Int64)
+	return counter, ok
+}
+
+func (m *MemoryStore) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return &m.locks[h.Sum32()%uint32(len(m.locks))]
+}
+
 // This just wraps around the set function
-func (m *MemoryStore) SetMany(ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+func (m *MemoryStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
 	for _, v := range values {
-		if err := m.Set(ns, v.Key, v); err != nil {
+		if err := m.Set(ctx, ns, v.Key, v); err != nil {
 			return err
 		}
 	}
@@ -131,7 +245,7 @@ func (m *MemoryStore) SetMany(ns types.TNamespace, values []types.TValue, opts *
 	return nil
 }
 
-func (m *MemoryStore) Remove(ns types.TNamespace, key []string) error {
+func (m *MemoryStore) Remove(ctx context.Context, ns types.TNamespace, key []string) error {
 	for _, k := range key {
 		m.otter.Delete(m.CreateCacheKey(ns, k))
 	}
@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/store/memory"
+	"github.com/steamsets/go-cache/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) cache.Store {
+		return memory.New(memory.Config{})
+	})
+}
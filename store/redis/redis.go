@@ -2,11 +2,17 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/goccy/go-json"
 	"github.com/redis/rueidis"
+	"github.com/steamsets/go-cache"
 	"github.com/steamsets/go-cache/pkg/types"
 )
 
@@ -31,14 +37,37 @@ func (r *RedisStore) Name() string {
 	return r.name
 }
 
+// Client returns the underlying client, e.g. for the lua scripts of the ratelimit package
+func (r *RedisStore) Client() rueidis.Client {
+	return r.config.Client
+}
+
+func (r *RedisStore) Ping(ctx context.Context) error {
+	return r.config.Client.Do(ctx, r.config.Client.B().Ping().Build()).Error()
+}
+
+// Time returns the time of the redis server
+func (r *RedisStore) Time(ctx context.Context) (time.Time, error) {
+	t, err := r.config.Client.Do(ctx, r.config.Client.B().Time().Build()).AsIntSlice()
+	if err != nil {
+		return time.Time{}, r.storeError("", "time", nil, err)
+	}
+
+	if len(t) != 2 {
+		return time.Time{}, cache.NewStoreError(r.name, "", "time", nil, cache.ErrDecode, fmt.Errorf("unexpected TIME reply %v", t))
+	}
+
+	return time.Unix(t[0], t[1]*int64(time.Microsecond)), nil
+}
+
 func (r *RedisStore) CreateCacheKey(namespace types.TNamespace, key string) string {
 	return string(namespace) + "::" + key
 }
 
-func (r *RedisStore) Get(ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
+func (r *RedisStore) Get(ctx context.Context, ns types.TNamespace, key string, T any) (value types.TValue, found bool, err error) {
 	var resp rueidis.RedisResult
 
-	resp = r.config.Client.DoCache(context.Background(), r.config.Client.B().Get().Key(r.CreateCacheKey(ns, key)).Cache(), time.Minute)
+	resp = r.config.Client.DoCache(ctx, r.config.Client.B().Get().Key(r.CreateCacheKey(ns, key)).Cache(), time.Minute)
 
 	msg, err := resp.ToMessage()
 	if err == rueidis.Nil {
@@ -46,17 +75,17 @@ func (r *RedisStore) Get(ns types.TNamespace, key string, T any) (value types.TV
 	}
 
 	if err != nil {
-		return value, false, err
+		return value, false, r.storeError(ns, "get", []string{key}, err)
 	}
 
 	b, err := msg.AsBytes()
 	if err != nil {
-		return value, true, err
+		return value, true, cache.NewStoreError(r.name, ns, "get", []string{key}, cache.ErrDecode, err)
 	}
 
 	v, err := types.SetTIntoTValue(b, T)
 	if err != nil {
-		return value, true, err
+		return value, true, cache.NewStoreError(r.name, ns, "get", []string{key}, cache.ErrDecode, err)
 	}
 
 	value = *v
@@ -64,10 +93,8 @@ func (r *RedisStore) Get(ns types.TNamespace, key string, T any) (value types.TV
 	return value, true, nil
 }
 
-func (r *RedisStore) GetMany(ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
-	ctx := context.Background()
-
-	keysToGet := make([]string, 0)
+func (r *RedisStore) GetMany(ctx context.Context, ns types.TNamespace, keys []string, T any) ([]types.TValue, error) {
+	keysToGet := make([]string, 0, len(keys))
 	for _, k := range keys {
 		keysToGet = append(keysToGet, r.CreateCacheKey(ns, k))
 	}
@@ -75,61 +102,63 @@ func (r *RedisStore) GetMany(ns types.TNamespace, keys []string, T any) ([]types
 	ret, err := rueidis.MGetCache(r.config.Client, ctx, time.Minute, keysToGet)
 
 	if err != nil {
-		return nil, err
+		return nil, r.storeError(ns, "get-many", keys, err)
 	}
 
-	values := make([]types.TValue, 0)
-	for str, v := range ret {
+	values := make([]types.TValue, 0, len(keys))
+	for i, key := range keys {
+		v, ok := ret[keysToGet[i]]
 		keyError := v.Error()
-		if keyError == rueidis.Nil {
+		if !ok || keyError == rueidis.Nil {
 			values = append(values, types.TValue{
 				Found: false,
 				Value: nil,
-				Key:   str,
+				Key:   key,
 			})
 			continue
 		}
 
 		if keyError != nil {
-			return values, keyError
+			return nil, r.storeError(ns, "get-many", keys, keyError)
 		}
 
 		raw, err := v.AsBytes()
 		if err != nil {
-			return values, err
+			return nil, cache.NewStoreError(r.name, ns, "get-many", []string{key}, cache.ErrDecode, err)
 		}
 
 		localT := reflect.New(reflect.TypeOf(T).Elem()).Interface()
 
-		v, err := types.SetTIntoTValue(raw, localT)
+		value, err := types.SetTIntoTValue(raw, localT)
 		if err != nil {
-			return nil, err
+			return nil, cache.NewStoreError(r.name, ns, "get-many", []string{key}, cache.ErrDecode, err)
 		}
 
-		v.Found = true
-		values = append(values, *v)
+		value.Key = key
+		value.Found = true
+		values = append(values, *value)
 	}
 
 	return values, nil
 }
 
-func (r *RedisStore) Set(ns types.TNamespace, key string, value types.TValue) error {
+func (r *RedisStore) Set(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
 	b, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
 	if err := r.config.Client.Do(
-		context.Background(),
+		ctx,
 		r.config.Client.B().Set().Key(r.CreateCacheKey(ns, key)).Value(string(b)).Pxat(value.StaleUntil).Build(),
 	).Error(); err != nil {
-		return err
+		return r.storeError(ns, "set", []string{key}, err)
 	}
 
 	return nil
 }
 
-func (r *RedisStore) SetMany(ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+func (r *RedisStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
 	cmd := r.config.Client.B().Mset()
 	for _, v := range values {
 		b, err := json.Marshal(v)
@@ -141,20 +170,122 @@ func (r *RedisStore) SetMany(ns types.TNamespace, values []types.TValue, opts *t
 		cmd.KeyValue().KeyValue(r.CreateCacheKey(ns, v.Key), string(b))
 	}
 
-	if err := r.config.Client.Do(context.Background(), cmd.KeyValue().Build()).Error(); err != nil {
+	if err := r.config.Client.Do(ctx, cmd.KeyValue().Build()).Error(); err != nil {
+		keys := make([]string, 0, len(values))
+		for _, v := range values {
+			keys = append(keys, v.Key)
+		}
+
+		return r.storeError(ns, "set-many", keys, err)
+	}
+
+	return nil
+}
+
+func (r *RedisStore) SetIfAbsent(ctx context.Context, ns types.TNamespace, key string, value types.TValue) error {
+	b, err := json.Marshal(value)
+	if err != nil {
 		return err
 	}
 
+	err = r.config.Client.Do(
+		ctx,
+		r.config.Client.B().Set().Key(r.CreateCacheKey(ns, key)).Value(string(b)).Nx().Pxat(value.StaleUntil).Build(),
+	).Error()
+
+	// NX answers with nil if the key already exists
+	if rueidis.IsRedisNil(err) {
+		return cache.NewStoreError(r.name, ns, "set-if-absent", []string{key}, cache.ErrConflict, cache.ErrConflict)
+	}
+
+	return r.storeError(ns, "set-if-absent", []string{key}, err)
+}
+
+// compareAndSwap only sets the key if the Revision in its json is still the expected one.
+// cjson decodes numbers into doubles, which can't hold every revision, so the digits are compared as they are stored.
+// Revision is the last field of the json and left out if it is 0
+var compareAndSwap = rueidis.NewLuaScript(`
+local current = redis.call('GET', KEYS[1])
+if not current then
+	return 0
+end
+
+local ok = pcall(cjson.decode, current)
+local revision = string.match(current, '"Revision":(%d+)}%s*$') or '0'
+if not ok or revision ~= ARGV[1] then
+	return 0
+end
+
+redis.call('SET', KEYS[1], ARGV[2], 'PXAT', ARGV[3])
+return 1
+`)
+
+func (r *RedisStore) CompareAndSwap(ctx context.Context, ns types.TNamespace, key string, expected uint64, value types.TValue) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	swapped, err := compareAndSwap.Exec(
+		ctx,
+		r.config.Client,
+		[]string{r.CreateCacheKey(ns, key)},
+		[]string{strconv.FormatUint(expected, 10), string(b), strconv.FormatInt(value.StaleUntil.UnixMilli(), 10)},
+	).AsInt64()
+	if err != nil {
+		return r.storeError(ns, "compare-and-swap", []string{key}, err)
+	}
+
+	if swapped == 0 {
+		return cache.NewStoreError(r.name, ns, "compare-and-swap", []string{key}, cache.ErrConflict, cache.ErrConflict)
+	}
+
 	return nil
 }
 
-func (r *RedisStore) Remove(ns types.TNamespace, key []string) error {
+// increment sets the ttl only when INCRBY created the key, so increments don't extend it
+var increment = rueidis.NewLuaScript(`
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return value
+`)
+
+func (r *RedisStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := increment.Exec(
+		ctx,
+		r.config.Client,
+		[]string{r.CreateCacheKey(ns, key)},
+		[]string{strconv.FormatInt(delta, 10), strconv.FormatInt(ttl.Milliseconds(), 10)},
+	).AsInt64()
+	if err != nil {
+		return 0, r.storeError(ns, "increment", []string{key}, err)
+	}
+
+	return value, nil
+}
+
+func (r *RedisStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	value, err := r.config.Client.Do(ctx, r.config.Client.B().Get().Key(r.CreateCacheKey(ns, key)).Build()).AsInt64()
+	if rueidis.IsRedisNil(err) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, r.storeError(ns, "counter", []string{key}, err)
+	}
+
+	return value, true, nil
+}
+
+func (r *RedisStore) Remove(ctx context.Context, ns types.TNamespace, key []string) error {
 	keys := make([]string, 0)
 	for _, k := range key {
 		keys = append(keys, r.CreateCacheKey(ns, k))
 	}
 
-	res := r.config.Client.Do(context.Background(), r.config.Client.B().Del().Key(keys...).Build())
+	res := r.config.Client.Do(ctx, r.config.Client.B().Del().Key(keys...).Build())
 
 	msg, err := res.ToMessage()
 	if err == rueidis.Nil {
@@ -162,12 +293,61 @@ func (r *RedisStore) Remove(ns types.TNamespace, key []string) error {
 	}
 
 	if err != nil {
-		return err
+		return r.storeError(ns, "remove", key, err)
 	}
 
 	if msg.Error() != nil {
-		return msg.Error()
+		return r.storeError(ns, "remove", key, msg.Error())
 	}
 
 	return nil
 }
+
+// storeError wraps err with the store, namespace and keys it failed for, dropped connections are reported as cache.ErrStoreUnavailable
+func (r *RedisStore) storeError(ns types.TNamespace, op string, keys []string, err error) error {
+	var kind error
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		kind = cache.ErrTimeout
+	} else if errors.As(err, &netErr) || errors.Is(err, rueidis.ErrClosing) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		kind = cache.ErrStoreUnavailable
+	}
+
+	return cache.NewStoreError(r.name, ns, op, keys, kind, err)
+}
+
+// IsRetryable reports whether err is a transient error, e.g. a failover or a dropped connection,
+// that is worth retrying. It can be used as the classifier of the retry middleware.
+func IsRetryable(err error) bool {
+	if err == nil || rueidis.IsRedisNil(err) {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if redisErr, ok := rueidis.IsRedisErr(err); ok {
+		if _, moved := redisErr.IsMoved(); moved {
+			return true
+		}
+
+		if _, ask := redisErr.IsAsk(); ask {
+			return true
+		}
+
+		return redisErr.IsTryAgain() || redisErr.IsLoading() || redisErr.IsClusterDown()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, rueidis.ErrClosing) ||
+		errors.Is(err, rueidis.ErrDoCacheAborted) ||
+		errors.Is(err, rueidis.ErrNoSlot) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,94 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/rueidis"
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/redis"
+	"github.com/steamsets/go-cache/storetest"
+)
+
+// newStore returns a store on an in-process redis server that is stopped with the test
+func newStore(t *testing.T) *redis.RedisStore {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+
+	// miniredis only expires keys when it is told that time passed
+	ticker := time.NewTicker(50 * time.Millisecond)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	t.Cleanup(func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				server.FastForward(50 * time.Millisecond)
+			}
+		}
+	}()
+
+	client, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress: []string{server.Addr()},
+		// miniredis has no client side caching and answers CLUSTER SLOTS, which would turn on cluster mode
+		DisableCache:      true,
+		ForceSingleClient: true,
+	})
+	if err != nil {
+		t.Skipf("redis can't be reached: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	return redis.New(redis.Config{Client: client})
+}
+
+func TestConformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) cache.Store {
+		return newStore(t)
+	})
+}
+
+func TestCompareAndSwapLargeRevision(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	// both revisions are the same double, only comparing the digits tells them apart
+	revision := uint64(1<<62 + 1)
+	value := types.TValue{Key: "key", Value: "first", StaleUntil: time.Now().Add(time.Hour), Revision: revision}
+	if err := store.Set(ctx, "ns", "key", value); err != nil {
+		t.Fatal(err)
+	}
+
+	value.Value = "second"
+	value.Revision = revision + 1
+	if err := store.CompareAndSwap(ctx, "ns", "key", revision-1, value); !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("CompareAndSwap with a revision one off got %v, want ErrConflict", err)
+	}
+
+	if err := store.CompareAndSwap(ctx, "ns", "key", revision, value); err != nil {
+		t.Fatalf("CompareAndSwap with the current revision got %v", err)
+	}
+
+	var got string
+	current, _, err := store.Get(ctx, "ns", "key", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current.Revision != revision+1 || current.Value != "second" {
+		t.Fatalf("got revision %d and %v, want the swapped value", current.Revision, current.Value)
+	}
+}
@@ -0,0 +1,140 @@
+// Package storetest checks that a cache.Store behaves like the stores of this module.
+//
+// Call RunConformance from a test of the store, e.g.
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.RunConformance(t, func(t *testing.T) cache.Store {
+//			return memory.New(memory.Config{})
+//		})
+//	}
+//
+// Stores that need a server, like redis or memcached, are best run against an in-process stand-in, see the
+// tests of both. libsql works with a SQLite file in t.TempDir(), see the README for the table it needs.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// Factory returns the store to test, it is called once for every test. Every test uses its own namespace,
+// so the same store can be returned every time
+type Factory func(t *testing.T) cache.Store
+
+// Value is what the tests write, stores get a *Value as T
+type Value struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+// RunConformance runs all tests against the store returned by factory.
+// Expiry waits for values to expire, which takes about two seconds
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("GetMiss", func(t *testing.T) { testGetMiss(t, factory) })
+	t.Run("SetGet", func(t *testing.T) { testSetGet(t, factory) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, factory) })
+	t.Run("SetMany", func(t *testing.T) { testSetMany(t, factory) })
+	t.Run("Remove", func(t *testing.T) { testRemove(t, factory) })
+	t.Run("KeyMapping", func(t *testing.T) { testKeyMapping(t, factory) })
+	t.Run("GetManyLogicalKeys", func(t *testing.T) { testGetManyLogicalKeys(t, factory) })
+	t.Run("GetManyOrder", func(t *testing.T) { testGetManyOrder(t, factory) })
+	t.Run("GetManyMisses", func(t *testing.T) { testGetManyMisses(t, factory) })
+	t.Run("Expiry", func(t *testing.T) { testExpiry(t, factory) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+	t.Run("LargeValue", func(t *testing.T) { testLargeValue(t, factory) })
+}
+
+// RunGetMany only runs the tests of the GetMany contract: logical keys, the order of the keys and misses.
+// It is part of RunConformance, use it for middlewares that change keys or values on their way to the store
+func RunGetMany(t *testing.T, factory Factory) {
+	t.Run("GetManyLogicalKeys", func(t *testing.T) { testGetManyLogicalKeys(t, factory) })
+	t.Run("GetManyOrder", func(t *testing.T) { testGetManyOrder(t, factory) })
+	t.Run("GetManyMisses", func(t *testing.T) { testGetManyMisses(t, factory) })
+}
+
+func setup(t *testing.T, factory Factory) (context.Context, cache.Store, types.TNamespace) {
+	t.Helper()
+
+	store := factory(t)
+	// leftovers of earlier runs can't interfere, they expire with their values
+	ns := types.TNamespace(fmt.Sprintf("storetest-%d", rand.Uint64()))
+
+	return context.Background(), store, ns
+}
+
+// newValue returns a value that is fresh for a minute and stale for an hour.
+// The times are rounded to seconds, which is all that some stores keep
+func newValue(key string, value Value) types.TValue {
+	now := time.Now().Truncate(time.Second)
+
+	return types.TValue{
+		Key:        key,
+		Value:      &value,
+		FreshUntil: now.Add(time.Minute),
+		StaleUntil: now.Add(time.Hour),
+	}
+}
+
+func set(ctx context.Context, t *testing.T, store cache.Store, ns types.TNamespace, key string, value Value) {
+	t.Helper()
+
+	if err := store.Set(ctx, ns, key, newValue(key, value)); err != nil {
+		t.Fatalf("Set %q: %v", key, err)
+	}
+}
+
+func get(ctx context.Context, t *testing.T, store cache.Store, ns types.TNamespace, key string) (types.TValue, bool) {
+	t.Helper()
+
+	value, found, err := store.Get(ctx, ns, key, &Value{})
+	if err != nil {
+		t.Fatalf("Get %q: %v", key, err)
+	}
+
+	// Found is only meant for GetMany, Get reports it separately
+	value.Found = found
+	return value, found
+}
+
+// decode returns the value of a store, stores that keep values as they are return the *Value that was written
+func decode(value types.TValue) (Value, bool) {
+	switch v := value.Value.(type) {
+	case Value:
+		return v, true
+	case *Value:
+		if v == nil {
+			return Value{}, false
+		}
+
+		return *v, true
+	}
+
+	return Value{}, false
+}
+
+// expectValue checks that value was found and has the name of want
+func expectValue(t *testing.T, value types.TValue, key string, want Value) {
+	t.Helper()
+
+	if !value.Found {
+		t.Errorf("%q was not found", key)
+		return
+	}
+
+	got, ok := decode(value)
+	if !ok {
+		t.Errorf("%q has a value of type %T, want storetest.Value", key, value.Value)
+		return
+	}
+
+	if got.Name != want.Name {
+		t.Errorf("%q has name %q, want %q", key, got.Name, want.Name)
+	}
+}
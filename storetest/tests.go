@@ -0,0 +1,363 @@
+package storetest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// testGetMiss checks that a missing key is not found and not an error
+func testGetMiss(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	if _, found := get(ctx, t, store, ns, "missing"); found {
+		t.Errorf("missing key was found")
+	}
+}
+
+// testSetGet checks that a value and its metadata are returned as they were written
+func testSetGet(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	want := newValue("key", Value{Name: "name", Count: 42, Tags: []string{"a", "b"}})
+	want.SchemaVersion = 3
+	want.Revision = 7
+
+	if err := store.Set(ctx, ns, "key", want); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	value, found := get(ctx, t, store, ns, "key")
+	if !found {
+		t.Fatalf("key was not found")
+	}
+
+	got, ok := decode(value)
+	if !ok {
+		t.Fatalf("value has type %T, want storetest.Value", value.Value)
+	}
+
+	if got.Name != "name" || got.Count != 42 || strings.Join(got.Tags, ",") != "a,b" {
+		t.Errorf("got %+v, want %+v", got, *want.Value.(*Value))
+	}
+
+	if !value.FreshUntil.Equal(want.FreshUntil) {
+		t.Errorf("FreshUntil is %s, want %s", value.FreshUntil, want.FreshUntil)
+	}
+
+	if !value.StaleUntil.Equal(want.StaleUntil) {
+		t.Errorf("StaleUntil is %s, want %s", value.StaleUntil, want.StaleUntil)
+	}
+
+	if value.SchemaVersion != want.SchemaVersion {
+		t.Errorf("SchemaVersion is %d, want %d", value.SchemaVersion, want.SchemaVersion)
+	}
+
+	if value.Revision != want.Revision {
+		t.Errorf("Revision is %d, want %d", value.Revision, want.Revision)
+	}
+}
+
+// testOverwrite checks that the last Set wins
+func testOverwrite(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	set(ctx, t, store, ns, "key", Value{Name: "first"})
+	set(ctx, t, store, ns, "key", Value{Name: "second"})
+
+	value, _ := get(ctx, t, store, ns, "key")
+	expectValue(t, value, "key", Value{Name: "second"})
+}
+
+// testSetMany checks that all values of SetMany can be read with Get and GetMany
+func testSetMany(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	keys := make([]string, 0, 10)
+	values := make([]types.TValue, 0, 10)
+	for i := range 10 {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		values = append(values, newValue(key, Value{Name: key, Count: i}))
+	}
+
+	if err := store.SetMany(ctx, ns, values, nil); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+
+	for _, key := range keys {
+		value, _ := get(ctx, t, store, ns, key)
+		expectValue(t, value, key, Value{Name: key})
+	}
+
+	found, err := store.GetMany(ctx, ns, keys, &Value{})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+
+	if len(found) != len(keys) {
+		t.Fatalf("GetMany returned %d values for %d keys", len(found), len(keys))
+	}
+
+	for i, key := range keys {
+		expectValue(t, found[i], key, Value{Name: key})
+	}
+}
+
+// testRemove checks that removed keys are gone, the others are kept and missing keys are no error
+func testRemove(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	set(ctx, t, store, ns, "a", Value{Name: "a"})
+	set(ctx, t, store, ns, "b", Value{Name: "b"})
+	set(ctx, t, store, ns, "c", Value{Name: "c"})
+
+	if err := store.Remove(ctx, ns, []string{"a", "c", "missing"}); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if _, found := get(ctx, t, store, ns, key); found {
+			t.Errorf("%q was found after it was removed", key)
+		}
+	}
+
+	value, _ := get(ctx, t, store, ns, "b")
+	expectValue(t, value, "b", Value{Name: "b"})
+}
+
+// testKeyMapping checks that keys are not changed on their way to the store and back
+// and that namespaces don't share keys
+func testKeyMapping(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	keys := []string{"user:1", "a/b", "a::b", "ключ", "with.dot", "UPPER", "upper"}
+	for _, key := range keys {
+		set(ctx, t, store, ns, key, Value{Name: key})
+	}
+
+	for _, key := range keys {
+		value, _ := get(ctx, t, store, ns, key)
+		expectValue(t, value, key, Value{Name: key})
+	}
+
+	values, err := store.GetMany(ctx, ns, keys, &Value{})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+
+	for i, key := range keys {
+		if i >= len(values) || values[i].Key != key {
+			t.Errorf("GetMany did not return %q at %d", key, i)
+			continue
+		}
+
+		expectValue(t, values[i], key, Value{Name: key})
+	}
+
+	if _, found := get(ctx, t, store, ns+"-other", keys[0]); found {
+		t.Errorf("%q was found in another namespace", keys[0])
+	}
+}
+
+// testGetManyLogicalKeys checks that GetMany returns the keys that were asked for, not the keys of CreateCacheKey
+func testGetManyLogicalKeys(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	set(ctx, t, store, ns, "user:1", Value{Name: "one"})
+
+	values, err := store.GetMany(ctx, ns, []string{"user:1"}, &Value{})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+
+	if len(values) != 1 {
+		t.Fatalf("GetMany returned %d values for 1 key", len(values))
+	}
+
+	if values[0].Key != "user:1" {
+		t.Errorf("GetMany returned key %q, want %q (CreateCacheKey is %q)", values[0].Key, "user:1", store.CreateCacheKey(ns, "user:1"))
+	}
+}
+
+// testGetManyOrder checks that GetMany returns the values in the order of the keys
+func testGetManyOrder(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	keys := make([]string, 0, 20)
+	for i := range 20 {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		set(ctx, t, store, ns, key, Value{Name: key, Count: i})
+	}
+
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+
+	values, err := store.GetMany(ctx, ns, keys, &Value{})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+
+	if len(values) != len(keys) {
+		t.Fatalf("GetMany returned %d values for %d keys", len(values), len(keys))
+	}
+
+	for i, key := range keys {
+		if values[i].Key != key {
+			t.Errorf("value %d has key %q, want %q", i, values[i].Key, key)
+			continue
+		}
+
+		expectValue(t, values[i], key, Value{Name: key})
+	}
+}
+
+// testGetManyMisses checks that misses are part of the result with Found set to false
+func testGetManyMisses(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	set(ctx, t, store, ns, "b", Value{Name: "b"})
+
+	keys := []string{"a", "b", "c"}
+	values, err := store.GetMany(ctx, ns, keys, &Value{})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+
+	if len(values) != len(keys) {
+		t.Fatalf("GetMany returned %d values for %d keys", len(values), len(keys))
+	}
+
+	for i, key := range keys {
+		if values[i].Key != key {
+			t.Errorf("value %d has key %q, want %q", i, values[i].Key, key)
+		}
+	}
+
+	if values[0].Found || values[2].Found {
+		t.Errorf("missing keys are reported as found")
+	}
+
+	expectValue(t, values[1], "b", Value{Name: "b"})
+}
+
+// testExpiry checks that values are gone once StaleUntil passed. Some stores only expire by the second,
+// so the value expires at the next full second and is read again a second later
+func testExpiry(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	expiresAt := time.Now().Truncate(time.Second).Add(time.Second)
+	if err := store.Set(ctx, ns, "expires", types.TValue{
+		Key:        "expires",
+		Value:      &Value{Name: "expires"},
+		FreshUntil: expiresAt,
+		StaleUntil: expiresAt,
+	}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	set(ctx, t, store, ns, "stays", Value{Name: "stays"})
+
+	value, _ := get(ctx, t, store, ns, "expires")
+	expectValue(t, value, "expires", Value{Name: "expires"})
+
+	time.Sleep(time.Until(expiresAt.Add(time.Second)))
+
+	if _, found := get(ctx, t, store, ns, "expires"); found {
+		t.Errorf("value was found after StaleUntil")
+	}
+
+	values, err := store.GetMany(ctx, ns, []string{"expires", "stays"}, &Value{})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+
+	if len(values) != 2 {
+		t.Fatalf("GetMany returned %d values for 2 keys", len(values))
+	}
+
+	if values[0].Found {
+		t.Errorf("GetMany found the value after StaleUntil")
+	}
+
+	expectValue(t, values[1], "stays", Value{Name: "stays"})
+}
+
+// testConcurrency checks that concurrent writes and reads of the same and different keys don't fail
+// and that a read returns one of the written values
+func testConcurrency(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	const workers = 16
+	const rounds = 20
+
+	wg := sync.WaitGroup{}
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			own := fmt.Sprintf("own-%d", w)
+			for i := range rounds {
+				if err := store.Set(ctx, ns, "shared", newValue("shared", Value{Name: "shared", Count: w})); err != nil {
+					t.Errorf("Set shared: %v", err)
+					return
+				}
+
+				if err := store.Set(ctx, ns, own, newValue(own, Value{Name: own, Count: i})); err != nil {
+					t.Errorf("Set %q: %v", own, err)
+					return
+				}
+
+				value, found, err := store.Get(ctx, ns, own, &Value{})
+				if err != nil {
+					t.Errorf("Get %q: %v", own, err)
+					return
+				}
+
+				if got, ok := decode(value); !found || !ok || got.Count != i {
+					t.Errorf("%q has %+v, want count %d", own, got, i)
+					return
+				}
+
+				if _, err := store.GetMany(ctx, ns, []string{"shared", own}, &Value{}); err != nil {
+					t.Errorf("GetMany: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, _ := get(ctx, t, store, ns, "shared")
+	got, ok := decode(value)
+	if !ok || got.Count < 0 || got.Count >= workers {
+		t.Errorf("shared has %+v, which was never written", got)
+	}
+}
+
+// testLargeValue checks that a value of 256KB survives, memcached stores up to 1MB by default
+func testLargeValue(t *testing.T, factory Factory) {
+	ctx, store, ns := setup(t, factory)
+
+	tags := make([]string, 0, 1024)
+	for i := range 1024 {
+		tags = append(tags, fmt.Sprintf("%04d-%s", i, strings.Repeat("x", 251)))
+	}
+
+	set(ctx, t, store, ns, "large", Value{Name: "large", Tags: tags})
+
+	value, _ := get(ctx, t, store, ns, "large")
+	expectValue(t, value, "large", Value{Name: "large"})
+
+	got, _ := decode(value)
+	if len(got.Tags) != len(tags) || got.Tags[len(tags)-1] != tags[len(tags)-1] {
+		t.Errorf("large value came back with %d tags, want %d", len(got.Tags), len(tags))
+	}
+}
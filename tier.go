@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+// Tier wraps a store with settings that only apply to that store,
+// e.g. memory only keeping values for 10 seconds while redis keeps them for 10 minutes.
+type Tier struct {
+	Store Store
+	// Used instead of the namespace Fresh and Stale for values written to this store, 0 keeps the namespace ones
+	Fresh time.Duration
+	Stale time.Duration
+	// Values whose json encoding is bigger than this many bytes are not written to this store, 0 means no limit
+	MaxValueSize int
+	// The store is skipped by Get and GetMany
+	DisableReads bool
+	// The store is skipped by Set, SetMany and backfills, removes still go to it
+	DisableWrites bool
+	// Caps how long a value backfilled from a slower store lives in this store, 0 means no cap
+	BackfillTTL time.Duration
+}
+
+type tier struct {
+	// index of the tier, faster tiers come first
+	index int
+	// nil if circuit breakers are disabled
+	breaker *circuitBreaker
+	Tier
+}
+
+func newTiers(stores []Store, tiers []Tier) []tier {
+	if len(tiers) == 0 {
+		for _, store := range stores {
+			tiers = append(tiers, Tier{Store: store})
+		}
+	}
+
+	ret := make([]tier, 0, len(tiers))
+	for i, t := range tiers {
+		ret = append(ret, tier{index: i, Tier: t})
+	}
+
+	return ret
+}
+
+func writableTiers(tiers []tier) []tier {
+	ret := make([]tier, 0, len(tiers))
+	for _, t := range tiers {
+		if !t.DisableWrites {
+			ret = append(ret, t)
+		}
+	}
+
+	return ret
+}
+
+// pendingValue is a value before it gets the fresh and stale times of the tier it is written to
+type pendingValue struct {
+	value types.TValue
+	opts  *types.SetOptions
+	at    time.Time
+	// size of the json encoded value, only set if one of the tiers has a MaxValueSize
+	size int
+}
+
+func valueSize(value any) int {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+
+	return len(b)
+}
+
+// values returns the pending values as they are written to this tier
+func (t tier) values(pending []pendingValue, fresh time.Duration, stale time.Duration) []types.TValue {
+	if t.Fresh != 0 {
+		fresh = t.Fresh
+	}
+	if t.Stale != 0 {
+		stale = t.Stale
+	}
+
+	values := make([]types.TValue, 0, len(pending))
+	for _, p := range pending {
+		if t.MaxValueSize > 0 && p.size > t.MaxValueSize {
+			continue
+		}
+
+		v := p.value
+		v.FreshUntil, v.StaleUntil = getStaleFreshTime(p.at, fresh, stale, p.opts)
+		values = append(values, v)
+	}
+
+	return values
+}
+
+// backfillValues caps values found in a slower tier to how long this tier keeps them
+func (t tier) backfillValues(values []types.TValue, now time.Time) []types.TValue {
+	staleCap := t.Stale
+	if t.BackfillTTL > 0 && (staleCap == 0 || t.BackfillTTL < staleCap) {
+		staleCap = t.BackfillTTL
+	}
+
+	ret := make([]types.TValue, 0, len(values))
+	for _, v := range values {
+		if t.MaxValueSize > 0 && valueSize(v.Value) > t.MaxValueSize {
+			continue
+		}
+
+		if staleCap > 0 && v.StaleUntil.After(now.Add(staleCap)) {
+			v.StaleUntil = now.Add(staleCap)
+		}
+		if t.Fresh > 0 && v.FreshUntil.After(now.Add(t.Fresh)) {
+			v.FreshUntil = now.Add(t.Fresh)
+		}
+		if v.FreshUntil.After(v.StaleUntil) {
+			v.FreshUntil = v.StaleUntil
+		}
+
+		ret = append(ret, v)
+	}
+
+	return ret
+}
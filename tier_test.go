@@ -0,0 +1,120 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/cachetest"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+func TestBackfillUsesTierSettings(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	now := clock.Now()
+
+	l1 := memory.New(memory.Config{Clock: clock})
+	l2 := memory.New(memory.Config{Clock: clock})
+	l3 := memory.New(memory.Config{Clock: clock})
+
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Tiers: []cache.Tier{
+			{Store: l1, Fresh: 10 * time.Second, Stale: 20 * time.Second},
+			{Store: l2},
+			{Store: l3},
+		},
+		Fresh: time.Minute,
+		Stale: time.Hour,
+		Clock: clock,
+	})
+
+	err := l2.Set(ctx, "user", "alice", types.TValue{
+		Key:        "alice",
+		Value:      user{Name: "alice"},
+		FreshUntil: now.Add(time.Minute),
+		StaleUntil: now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := ns.Get(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found || value.Name != "alice" {
+		t.Fatalf("got %v, found %v, want alice from the second tier", value, found)
+	}
+
+	backfilled, found, err := l1.Get(ctx, "user", "alice", &user{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found {
+		t.Fatal("hit in the second tier was not backfilled into the first")
+	}
+
+	if want := now.Add(10 * time.Second); !backfilled.FreshUntil.Equal(want) {
+		t.Fatalf("backfilled value is fresh until %v, want %v from the first tier's Fresh", backfilled.FreshUntil, want)
+	}
+
+	if want := now.Add(20 * time.Second); !backfilled.StaleUntil.Equal(want) {
+		t.Fatalf("backfilled value is stale until %v, want %v from the first tier's Stale", backfilled.StaleUntil, want)
+	}
+
+	if _, found, _ := l3.Get(ctx, "user", "alice", &user{}); found {
+		t.Fatal("hit in the second tier was backfilled into the slower third tier")
+	}
+
+	// the copy in the first tier expired, the second tier still has it
+	clock.Advance(30 * time.Second)
+	if _, found, _ := l1.Get(ctx, "user", "alice", &user{}); found {
+		t.Fatal("backfilled value outlived the Stale of the first tier")
+	}
+
+	if _, found, err := ns.Get(ctx, "alice"); err != nil || !found {
+		t.Fatalf("got found %v and %v, want the value of the second tier", found, err)
+	}
+}
+
+func TestSetUsesTierSettings(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	now := clock.Now()
+
+	l1 := memory.New(memory.Config{Clock: clock})
+	l2 := memory.New(memory.Config{Clock: clock})
+
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Tiers: []cache.Tier{
+			{Store: l1, Fresh: 10 * time.Second, Stale: 20 * time.Second},
+			{Store: l2, MaxValueSize: 10},
+		},
+		Fresh: time.Minute,
+		Stale: time.Hour,
+		Clock: clock,
+	})
+
+	if err := ns.Set(ctx, "alice", user{Name: "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := l1.Get(ctx, "user", "alice", &user{})
+	if err != nil || !found {
+		t.Fatalf("got found %v and %v from the first tier", found, err)
+	}
+
+	if !value.FreshUntil.Equal(now.Add(10*time.Second)) || !value.StaleUntil.Equal(now.Add(20*time.Second)) {
+		t.Fatalf("first tier keeps the value fresh until %v and stale until %v, want its own settings", value.FreshUntil, value.StaleUntil)
+	}
+
+	// {"Name":"alice"} is bigger than 10 bytes
+	if _, found, _ := l2.Get(ctx, "user", "alice", &user{}); found {
+		t.Fatal("value bigger than MaxValueSize was written to the second tier")
+	}
+}
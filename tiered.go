@@ -3,32 +3,133 @@ package cache
 import (
 	"context"
 	"errors"
-	"strings"
 	"time"
 
-	"github.com/Southclaws/fault"
-	"github.com/Southclaws/fault/fmsg"
+	"github.com/goccy/go-json"
+	"github.com/steamsets/go-cache/pkg/clock"
 	"github.com/steamsets/go-cache/pkg/telemetry"
 	"github.com/steamsets/go-cache/pkg/types"
 	"github.com/steamsets/go-cache/pkg/util"
 )
 
 type tieredCache[T any] struct {
-	stores    []Store
+	tiers     []tier
 	ns        types.TNamespace
 	fresh     time.Duration
 	stale     time.Duration
 	telemetry bool
+	policy    WritePolicy
+	// backfill values into faster stores without waiting for it
+	asyncBackfill bool
+	readMode      ReadMode
+	hedgeDelay    time.Duration
+	// whether any tier has a MaxValueSize, so the size of values needs to be known
+	needsSize bool
+	// only set if write behind is enabled, in that case only the first store is written synchronously
+	writeBehind *writeBehind
+	schema      *schemaGuard
+	// keyed by the schema version an entry was written with
+	migrations map[int]func(json.RawMessage) (*T, error)
+	clock      clock.Clock
+	// orders the copies of conditional writes in the faster tiers
+	revisions *keyLocks
 }
 
-func newTieredCache[T any](ns types.TNamespace, stores []Store, fresh time.Duration, stale time.Duration, telemetry bool) tieredCache[T] {
-	return tieredCache[T]{
-		stores:    stores,
-		ns:        ns,
-		fresh:     fresh,
-		stale:     stale,
-		telemetry: telemetry,
+// ctx stops the background work of the tiers, like pinging stores with an open circuit
+func newTieredCache[T any](ctx context.Context, ns types.TNamespace, cfg NamespaceConfig, migrations map[int]func(json.RawMessage) (*T, error)) tieredCache[T] {
+	t := tieredCache[T]{
+		tiers:         newTiers(cfg.Stores, cfg.Tiers),
+		ns:            ns,
+		fresh:         cfg.Fresh,
+		stale:         cfg.Stale,
+		telemetry:     cfg.Telemetry,
+		policy:        cfg.WritePolicy,
+		asyncBackfill: cfg.AsyncBackfill,
+		readMode:      cfg.ReadMode,
+		hedgeDelay:    cfg.HedgeDelay,
+		schema:        newSchemaGuard(cfg.SchemaVersion, cfg.RemoveInvalidEntries),
+		migrations:    migrations,
+		revisions:     &keyLocks{},
+		clock:         clock.OrReal(cfg.Clock),
 	}
+
+	if t.hedgeDelay <= 0 {
+		t.hedgeDelay = 10 * time.Millisecond
+	}
+
+	for i, tier := range t.tiers {
+		if tier.MaxValueSize > 0 {
+			t.needsSize = true
+		}
+
+		if cfg.CircuitBreaker != nil {
+			t.tiers[i].breaker = newCircuitBreaker(ctx, ns, tier, *cfg.CircuitBreaker)
+		}
+	}
+
+	if cfg.WriteBehind != nil && len(t.tiers) > 1 {
+		t.writeBehind = newWriteBehind(ns, t.tiers[1:], cfg.Fresh, cfg.Stale, *cfg.WriteBehind)
+	}
+
+	return t
+}
+
+// syncTiers are the tiers that are written before Set and SetMany return
+func (t tieredCache[T]) syncTiers() []tier {
+	if t.writeBehind != nil {
+		return writableTiers(t.tiers[:1])
+	}
+
+	return writableTiers(t.tiers)
+}
+
+func (t tieredCache[T]) pending(key string, value any, opts *types.SetOptions, now time.Time) pendingValue {
+	p := pendingValue{
+		value: types.TValue{
+			Value:         value,
+			Key:           key,
+			SchemaVersion: t.schema.version,
+			Revision:      nextRevision(now, 0),
+		},
+		opts: opts,
+		at:   now,
+	}
+
+	if t.needsSize {
+		p.size = valueSize(value)
+	}
+
+	return p
+}
+
+func (t tieredCache[T]) health() []TierHealth {
+	health := make([]TierHealth, 0, len(t.tiers))
+	for _, tier := range t.tiers {
+		health = append(health, TierHealth{
+			Store: tier.Store.Name(),
+			Tier:  tier.index,
+			State: tier.breaker.current(),
+		})
+	}
+
+	return health
+}
+
+// without returns keys that are not in exclude
+func without(keys []string, exclude []string) []string {
+	excluded := make(map[string]struct{}, len(exclude))
+	for _, key := range exclude {
+		excluded[key] = struct{}{}
+	}
+
+	ret := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := excluded[key]; !ok {
+			ret = append(ret, key)
+		}
+	}
+
+	return ret
 }
 
 func (t tieredCache[T]) Name() string {
@@ -55,11 +156,20 @@ func (t tieredCache[T]) Get(ctx context.Context, ns types.TNamespace, key string
 	ctx, span := telemetry.NewSpan(ctx, "tiered.get")
 	defer span.End()
 
-	if len(t.stores) == 0 {
-		return nil, false, errors.New("no stores found")
+	if len(t.tiers) == 0 {
+		return nil, false, ErrNoStores
+	}
+
+	if t.readMode != ReadSequential {
+		return t.hedgedGet(ctx, ns, key)
 	}
 
-	for _, store := range t.stores {
+	for _, tier := range t.tiers {
+		if tier.DisableReads || !tier.breaker.allow() {
+			continue
+		}
+
+		store := tier.Store
 		var result T
 		ctx, span := telemetry.NewSpan(ctx, store.Name()+".get")
 		defer span.End()
@@ -67,33 +177,40 @@ func (t tieredCache[T]) Get(ctx context.Context, ns types.TNamespace, key string
 			telemetry.AttributeKV{Key: "key", Value: key},
 			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
 		)
-		value, found, err := store.Get(t.ns, key, &result)
-		if err != nil {
-			telemetry.RecordError(span, err)
-			return nil, false, fault.Wrap(err, fmsg.With(store.Name()+" failed to get key: "+key))
+
+		start := time.Now()
+		value, found, err := store.Get(ctx, t.ns, key, &result)
+
+		// the store answered fine, the entry is just of an older shape of T
+		undecodable := errors.Is(err, ErrDecode)
+		if undecodable {
+			tier.breaker.record(nil, time.Since(start))
+		} else {
+			tier.breaker.record(err, time.Since(start))
 		}
 
-		if value.Value != nil {
-			for _, lowerStore := range t.stores {
+		if err != nil && !undecodable {
+			telemetry.RecordError(span, err)
 
-				// No need to reset the value in our current store, just in all other ones.
-				if store.Name() == lowerStore.Name() {
-					continue
-				}
+			// once the circuit opened the store is treated as a miss, the next one or the origin can still answer
+			if tier.breaker.skips() {
+				continue
+			}
 
-				_, span := telemetry.NewSpan(ctx, lowerStore.Name()+".set")
-				defer span.End()
-				telemetry.WithAttributes(span,
-					telemetry.AttributeKV{Key: "key", Value: key},
-					telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
-				)
+			return nil, false, tierError(tier, ns, "get", []string{key}, err)
+		}
 
-				if err := lowerStore.Set(t.ns, key, value); err != nil {
-					telemetry.RecordError(span, err)
-					return nil, false, fault.Wrap(err, fmsg.With(lowerStore.Name()+" failed to set key: "+key))
-				}
+		if undecodable || (value.Value != nil && t.schema.mismatch(value)) {
+			var ok bool
+			if value, ok = t.upgrade(ctx, ns, tier, key, undecodable); !ok {
+				continue
+			}
+			found = true
+		}
 
-				span.End()
+		if value.Value != nil {
+			if err := t.backfill(ctx, ns, tier.index, []types.TValue{value}); err != nil {
+				return nil, false, err
 			}
 
 			return &value, found, nil
@@ -103,6 +220,49 @@ func (t tieredCache[T]) Get(ctx context.Context, ns types.TNamespace, key string
 	return nil, false, nil
 }
 
+// backfill writes values that were found in the tier at index hit to all faster tiers above it,
+// the tiers below already missed or are only slower to ask. Values are capped to what each tier keeps.
+func (t tieredCache[T]) backfill(ctx context.Context, ns types.TNamespace, hit int, values []types.TValue) error {
+	if hit == 0 || len(values) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for _, v := range values {
+		keys = append(keys, v.Key)
+	}
+
+	now := t.clock.Now()
+	set := func(ctx context.Context) error {
+		ctx, span := telemetry.NewSpan(ctx, "tiered.backfill")
+		defer span.End()
+		telemetry.WithAttributes(span,
+			telemetry.AttributeKV{Key: "keys", Value: keys},
+			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
+			telemetry.AttributeKV{Key: "tier", Value: hit},
+		)
+
+		err := fanOut(ctx, ns, "set-many", t.policy, writableTiers(t.tiers[:hit]), keys, func(ctx context.Context, tier tier) error {
+			values := tier.backfillValues(values, now)
+			if len(values) == 0 {
+				return nil
+			}
+
+			return tier.Store.SetMany(ctx, t.ns, values, nil)
+		})
+		telemetry.RecordError(span, err)
+
+		return err
+	}
+
+	if t.asyncBackfill {
+		go set(context.WithoutCancel(ctx))
+		return nil
+	}
+
+	return set(ctx)
+}
+
 func (t tieredCache[T]) GetMany(ctx context.Context, ns types.TNamespace, keys []string) ([]types.TValue, error) {
 	ctx, span := telemetry.NewSpan(ctx, "tiered.get-many")
 	defer span.End()
@@ -111,12 +271,11 @@ func (t tieredCache[T]) GetMany(ctx context.Context, ns types.TNamespace, keys [
 		telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
 	)
 
-	if len(t.stores) == 0 {
-		return nil, errors.New("no stores found")
+	if len(t.tiers) == 0 {
+		return nil, ErrNoStores
 	}
 
 	keysToGet := make(map[string]struct{}, 0)
-	valuesToSet := make([]types.TValue, 0)
 	foundValues := make(map[string]types.TValue)
 
 	// We need to check for all keys in all stores
@@ -127,11 +286,17 @@ func (t tieredCache[T]) GetMany(ctx context.Context, ns types.TNamespace, keys [
 		keysToGet[key] = struct{}{}
 	}
 
-	for _, store := range t.stores {
+	for _, tier := range t.tiers {
 		// we already found all keys
 		if len(keysToGet) == 0 {
 			break
 		}
+
+		if tier.DisableReads || !tier.breaker.allow() {
+			continue
+		}
+
+		store := tier.Store
 		keysToFind := util.MapToString(keysToGet)
 
 		var result T
@@ -142,15 +307,63 @@ func (t tieredCache[T]) GetMany(ctx context.Context, ns types.TNamespace, keys [
 			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
 		)
 
-		values, err := store.GetMany(t.ns, keysToFind, &result)
+		start := time.Now()
+		values, err := store.GetMany(ctx, t.ns, keysToFind, &result)
+		upgraded := make([]types.TValue, 0)
+
+		// a single entry that can't be decoded fails the whole call, so it is left out and the rest is asked for again
+		for errors.Is(err, ErrDecode) {
+			var storeErr *StoreError
+			if !errors.As(err, &storeErr) {
+				break
+			}
+
+			remaining := without(keysToFind, storeErr.Keys)
+			if len(remaining) == len(keysToFind) {
+				break
+			}
+
+			for _, key := range storeErr.Keys {
+				if v, ok := t.upgrade(ctx, ns, tier, key, true); ok {
+					upgraded = append(upgraded, v)
+				}
+			}
+			keysToFind = remaining
+			values, err = nil, nil
+
+			if len(keysToFind) > 0 {
+				values, err = store.GetMany(ctx, t.ns, keysToFind, &result)
+			}
+		}
+
+		// the store could not tell which entry failed, all of them are asked from the next tier
+		if errors.Is(err, ErrDecode) {
+			t.schema.undecodable.Add(1)
+			values, err = nil, nil
+		}
+		tier.breaker.record(err, time.Since(start))
+
 		if err != nil {
 			telemetry.RecordError(span, err)
-			return nil, fault.Wrap(err, fmsg.With(store.Name()+" failed to get keys: "+strings.Join(keys, ",")))
+
+			if tier.breaker.skips() {
+				continue
+			}
+
+			return nil, tierError(tier, ns, "get-many", keysToFind, err)
 		}
 
-		for _, v := range values {
+		valuesToSet := make([]types.TValue, 0)
+		for _, v := range append(values, upgraded...) {
+			if v.Found && t.schema.mismatch(v) {
+				var ok bool
+				if v, ok = t.upgrade(ctx, ns, tier, v.Key, false); !ok {
+					continue
+				}
+			}
+
 			if v.Found {
-				// Since we found it set it to the lower stores
+				// Since we found it set it to the faster stores
 				valuesToSet = append(valuesToSet, v)
 				// But we should not look for it again
 				delete(keysToGet, v.Key)
@@ -158,26 +371,8 @@ func (t tieredCache[T]) GetMany(ctx context.Context, ns types.TNamespace, keys [
 			}
 		}
 
-		if len(valuesToSet) > 0 {
-			for _, lowerStore := range t.stores {
-				if lowerStore.Name() == store.Name() {
-					continue
-				}
-
-				_, span := telemetry.NewSpan(ctx, lowerStore.Name()+".set-many")
-				defer span.End()
-				telemetry.WithAttributes(span,
-					telemetry.AttributeKV{Key: "keys_amount", Value: len(keysToGet)},
-					telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
-				)
-				if err := lowerStore.SetMany(t.ns, valuesToSet, nil); err != nil {
-					return nil, fault.Wrap(err, fmsg.With(lowerStore.Name()+" failed to set keys: "+strings.Join(keys, ",")))
-				}
-				span.End()
-			}
-
-			// Clear out again
-			valuesToSet = make([]types.TValue, 0)
+		if err := t.backfill(ctx, ns, tier.index, valuesToSet); err != nil {
+			return nil, err
 		}
 	}
 
@@ -207,31 +402,26 @@ func (t tieredCache[T]) Set(ctx context.Context, ns types.TNamespace, key string
 		telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
 	)
 
-	if len(t.stores) == 0 {
-		return errors.New("no stores found")
+	if len(t.tiers) == 0 {
+		return ErrNoStores
 	}
 
-	fresh, stale := getStaleFreshTime(time.Now(), t.fresh, t.stale, opts)
-	for _, store := range t.stores {
-		_, span2 := telemetry.NewSpan(ctx, store.Name()+".set")
-		defer span2.End()
-		telemetry.WithAttributes(span2,
-			telemetry.AttributeKV{Key: "key", Value: key},
-			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
-			telemetry.AttributeKV{Key: "fresh", Value: fresh.String()},
-			telemetry.AttributeKV{Key: "stale", Value: stale.String()},
-		)
+	pending := []pendingValue{t.pending(key, value, opts, t.clock.Now())}
 
-		if err := store.Set(t.ns, key, types.TValue{
-			Value:      value,
-			FreshUntil: fresh,
-			StaleUntil: stale,
-			Key:        key,
-		}); err != nil {
-			telemetry.RecordError(span2, err)
-			return fault.Wrap(err, fmsg.With(store.Name()+" failed to set key: "+key))
+	if err := fanOut(ctx, ns, "set", t.policy, t.syncTiers(), []string{key}, func(ctx context.Context, tier tier) error {
+		values := tier.values(pending, t.fresh, t.stale)
+		if len(values) == 0 {
+			return nil
 		}
-		span2.End()
+
+		return tier.Store.Set(ctx, t.ns, key, values[0])
+	}); err != nil {
+		telemetry.RecordError(span, err)
+		return err
+	}
+
+	if t.writeBehind != nil {
+		t.writeBehind.enqueue(ctx, pending...)
 	}
 
 	return nil
@@ -241,45 +431,42 @@ func (t *tieredCache[T]) SetMany(ctx context.Context, ns types.TNamespace, value
 	ctx, span := telemetry.NewSpan(ctx, "tiered.set-many")
 	defer span.End()
 
-	if len(t.stores) == 0 {
-		return errors.New("no stores found")
+	if len(t.tiers) == 0 {
+		return ErrNoStores
 	}
 
 	if len(values) == 0 {
 		return nil
 	}
 
-	now := time.Now()
-	valuesToSet := make([]types.TValue, 0)
+	now := t.clock.Now()
+	pending := make([]pendingValue, 0, len(values))
+	keys := make([]string, 0, len(values))
 
-	// adjust keys to have the correct stale times
 	for _, value := range values {
 		if value.Opts == nil && opts != nil {
 			value.Opts = opts
 		}
 
-		fresh, stale := getStaleFreshTime(now, t.fresh, t.stale, value.Opts)
-		valuesToSet = append(valuesToSet, types.TValue{
-			Value:      value.Value,
-			FreshUntil: fresh,
-			StaleUntil: stale,
-			Key:        value.Key,
-		})
+		pending = append(pending, t.pending(value.Key, value.Value, value.Opts, now))
+		keys = append(keys, value.Key)
 	}
 
-	for _, store := range t.stores {
-		_, span2 := telemetry.NewSpan(ctx, store.Name()+".set-many")
-		defer span2.End()
-		telemetry.WithAttributes(span2,
-			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
-		)
-
-		if err := store.SetMany(t.ns, valuesToSet, opts); err != nil {
-			telemetry.RecordError(span2, err)
-			return fault.Wrap(err, fmsg.With(store.Name()+" failed to set keys: "))
+	if err := fanOut(ctx, ns, "set-many", t.policy, t.syncTiers(), keys, func(ctx context.Context, tier tier) error {
+		// adjust keys to have the correct stale times for the tier
+		values := tier.values(pending, t.fresh, t.stale)
+		if len(values) == 0 {
+			return nil
 		}
 
-		span2.End()
+		return tier.Store.SetMany(ctx, t.ns, values, opts)
+	}); err != nil {
+		telemetry.RecordError(span, err)
+		return err
+	}
+
+	if t.writeBehind != nil {
+		t.writeBehind.enqueue(ctx, pending...)
 	}
 
 	return nil
@@ -289,23 +476,20 @@ func (t *tieredCache[T]) Remove(ctx context.Context, ns types.TNamespace, keys [
 	ctx, span := telemetry.NewSpan(ctx, "tiered.remove")
 	defer span.End()
 
-	if len(t.stores) == 0 {
-		return errors.New("no stores found")
+	if len(t.tiers) == 0 {
+		return ErrNoStores
 	}
 
-	for _, store := range t.stores {
-		_, span2 := telemetry.NewSpan(ctx, store.Name()+".remove")
-		defer span2.End()
-		telemetry.WithAttributes(span2,
-			telemetry.AttributeKV{Key: "key", Value: keys},
-			telemetry.AttributeKV{Key: "namespace", Value: string(ns)},
-		)
+	// Removes always go to all stores right away, queued writes for the keys would bring them back otherwise
+	if t.writeBehind != nil {
+		t.writeBehind.cancel(keys)
+	}
 
-		if err := store.Remove(t.ns, keys); err != nil {
-			telemetry.RecordError(span2, err)
-			return fault.Wrap(err, fmsg.With(store.Name()+" failed to remove key(s): "+strings.Join(keys, ",")))
-		}
-		span2.End()
+	if err := fanOut(ctx, ns, "remove", t.policy, t.tiers, keys, func(ctx context.Context, tier tier) error {
+		return tier.Store.Remove(ctx, t.ns, keys)
+	}); err != nil {
+		telemetry.RecordError(span, err)
+		return err
 	}
 
 	return nil
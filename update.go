@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+type UpdateOptions struct {
+	// Fresh and stale times of the updated value, by default it keeps the FreshUntil and StaleUntil of the entry.
+	// New entries always use these or the namespace defaults
+	SetOptions *types.SetOptions
+	// How often the update is tried again after a conflict before ErrConflict is returned, defaults to 10
+	MaxAttempts int
+	// Stores without CompareAndSwap are updated while holding a lease, which expires after this
+	// so a crashed instance can't block the key forever. fn has to finish within it, otherwise nothing is written
+	// and ErrConflict is returned. Defaults to 5 seconds
+	LeaseTTL time.Duration
+}
+
+// Update reads the key, passes it to fn and writes the result to all stores, without losing concurrent updates.
+// old is nil if the key does not exist, returning nil from fn leaves the entry as it is.
+// If the last store implements ConditionalStore, fn is called again whenever another update won,
+// otherwise a lease is taken with the CounterStore of the last store while fn runs.
+func (n Namespace[T]) Update(ctx context.Context, key string, fn func(old *T) (*T, error), opts *UpdateOptions) (*T, error) {
+	ctx, span := telemetry.NewSpan(ctx, "namespace.update")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "key", Value: key},
+		telemetry.AttributeKV{Key: "namespace", Value: string(n.ns)},
+	)
+
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 10
+	}
+
+	if opts.LeaseTTL <= 0 {
+		opts.LeaseTTL = 5 * time.Second
+	}
+
+	_, _, err := n.store.authority(n.ns, "update", key)
+	if errors.Is(err, ErrUnsupported) {
+		value, err := n.updateWithLease(ctx, key, fn, opts)
+		telemetry.RecordError(span, err)
+		return value, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		telemetry.WithAttributes(span, telemetry.AttributeKV{Key: "attempt", Value: attempt})
+
+		entry, found, err := n.store.getEntry(ctx, n.ns, key)
+		if err != nil {
+			telemetry.RecordError(span, err)
+			return nil, err
+		}
+
+		// expired entries and those of another schema version are a miss, but still have to be swapped out
+		exists := found || entry.Found
+		found = found && entry.Value != nil && n.store.clock.Now().Before(entry.StaleUntil)
+
+		var old *T
+		if found {
+			old = getT[T](entry.Value)
+		}
+
+		value, err := fn(old)
+		if err != nil {
+			return nil, err
+		}
+
+		if value == nil {
+			return old, nil
+		}
+
+		switch {
+		case found:
+			_, err = n.store.setConditional(ctx, n.ns, key, value, updateSetOptions(entry, opts, n.store.clock.Now()), &entry.Revision)
+		case exists:
+			_, err = n.store.setConditional(ctx, n.ns, key, value, opts.SetOptions, &entry.Revision)
+		default:
+			_, err = n.store.setConditional(ctx, n.ns, key, value, opts.SetOptions, nil)
+		}
+
+		if err == nil {
+			return value, nil
+		}
+
+		if !errors.Is(err, ErrConflict) || attempt >= opts.MaxAttempts {
+			telemetry.RecordError(span, err)
+			return nil, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// updateWithLease serializes updates with a counter in the last store, only the caller that incremented it first holds the lease
+func (n Namespace[T]) updateWithLease(ctx context.Context, key string, fn func(old *T) (*T, error), opts *UpdateOptions) (*T, error) {
+	last := n.store.tiers[len(n.store.tiers)-1]
+	leases, ok := Capability[CounterStore](last.Store)
+	if !ok {
+		return nil, tierError(last, n.ns, "update", []string{key}, ErrUnsupported)
+	}
+
+	// leases live in their own namespace so they don't show up as values
+	leaseNs := n.ns + "::lease"
+	deadline := n.store.clock.Now().Add(opts.LeaseTTL)
+	wait := 5 * time.Millisecond
+
+	// the lease expires LeaseTTL after it was taken at the latest, from then on it may belong to another caller
+	var expires time.Time
+
+	for {
+		expires = n.store.clock.Now().Add(opts.LeaseTTL)
+		holders, err := leases.Increment(ctx, leaseNs, key, 1, opts.LeaseTTL)
+		if err != nil {
+			return nil, tierError(last, n.ns, "update", []string{key}, err)
+		}
+
+		if holders == 1 {
+			break
+		}
+
+		// the counter expires with the lease, nobody else has to give it back
+		if n.store.clock.Now().After(deadline) {
+			return nil, tierError(last, n.ns, "update", []string{key}, ErrConflict)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		wait = min(wait*2, 200*time.Millisecond)
+	}
+
+	// A counter can't tell who holds it, so an expired lease is left alone instead of removing the one of the next caller
+	defer func() {
+		if n.store.clock.Now().Before(expires) {
+			leases.Remove(context.WithoutCancel(ctx), leaseNs, []string{key})
+		}
+	}()
+
+	entry, found, err := n.store.getEntry(ctx, n.ns, key)
+	if err != nil {
+		return nil, err
+	}
+
+	found = found && entry.Value != nil && n.store.clock.Now().Before(entry.StaleUntil)
+
+	var old *T
+	if found {
+		old = getT[T](entry.Value)
+	}
+
+	value, err := fn(old)
+	if err != nil {
+		return nil, err
+	}
+
+	if value == nil {
+		return old, nil
+	}
+
+	setOpts := opts.SetOptions
+	if found {
+		setOpts = updateSetOptions(entry, opts, n.store.clock.Now())
+	}
+
+	// another caller may have taken the lease and written already, fn has to run again with its value
+	if !n.store.clock.Now().Before(expires) {
+		return nil, tierError(last, n.ns, "update", []string{key}, fmt.Errorf("%w: lease expired before the value was written", ErrConflict))
+	}
+
+	if err := n.store.Set(ctx, n.ns, key, value, setOpts); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// updateSetOptions keeps the fresh and stale times of the entry unless the caller asked for new ones
+func updateSetOptions(entry types.TValue, opts *UpdateOptions, now time.Time) *types.SetOptions {
+	if opts.SetOptions != nil {
+		return opts.SetOptions
+	}
+
+	return &types.SetOptions{
+		Fresh: entry.FreshUntil.Sub(now),
+		Stale: entry.StaleUntil.Sub(now),
+	}
+}
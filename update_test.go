@@ -0,0 +1,142 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/cachetest"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+// counterOnlyStore hides CompareAndSwap of the memory store, so Update has to take a lease
+type counterOnlyStore struct {
+	cache.Store
+	counters cache.CounterStore
+}
+
+func (s counterOnlyStore) Increment(ctx context.Context, ns types.TNamespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	return s.counters.Increment(ctx, ns, key, delta, ttl)
+}
+
+func (s counterOnlyStore) Counter(ctx context.Context, ns types.TNamespace, key string) (int64, bool, error) {
+	return s.counters.Counter(ctx, ns, key)
+}
+
+func TestUpdateLeaseExpired(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	inner := memory.New(memory.Config{Clock: clock})
+	store := counterOnlyStore{Store: inner, counters: inner}
+
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{store},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+		Clock:  clock,
+	})
+
+	// the lease of the slow update expires while it runs, the fast one takes the next lease and holds it until the slow one is done
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	fast := make(chan error, 1)
+	_, err := ns.Update(ctx, "alice", func(old *user) (*user, error) {
+		clock.Advance(60 * time.Millisecond)
+
+		go func() {
+			_, err := ns.Update(ctx, "alice", func(old *user) (*user, error) {
+				close(entered)
+				<-release
+				return &user{Name: "fast"}, nil
+			}, &cache.UpdateOptions{LeaseTTL: time.Minute})
+			fast <- err
+		}()
+		<-entered
+
+		return &user{Name: "slow"}, nil
+	}, &cache.UpdateOptions{LeaseTTL: 50 * time.Millisecond})
+
+	if !errors.Is(err, cache.ErrConflict) {
+		t.Fatalf("update that outlived its lease got %v, want ErrConflict", err)
+	}
+
+	if _, found, _ := inner.Counter(ctx, "user::lease", "alice"); !found {
+		t.Fatal("update that outlived its lease removed the lease of the next one")
+	}
+
+	close(release)
+	if err := <-fast; err != nil {
+		t.Fatal(err)
+	}
+
+	value, _, err := ns.Get(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value == nil || value.Name != "fast" {
+		t.Fatalf("got %v, want the value of the update that held the lease", value)
+	}
+}
+
+func TestUpdateOtherSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(memory.Config{})
+
+	if err := newVersionedNamespace[userV1](store, 1, false).Set(ctx, "alice", userV1{FullName: "Alice Liddell"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entry of version 1 is a miss for version 2, but it is still stored and has to be swapped out
+	v2 := newVersionedNamespace[user](store, 2, false)
+	value, err := v2.Update(ctx, "alice", func(old *user) (*user, error) {
+		if old != nil {
+			t.Errorf("got %v, want an entry of another version to be passed as nil", old)
+		}
+		return &user{Name: "alice"}, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value == nil || value.Name != "alice" {
+		t.Fatalf("got %v, want the updated value", value)
+	}
+
+	entry, found, err := store.Get(ctx, "user", "alice", &user{})
+	if err != nil || !found || entry.SchemaVersion != 2 {
+		t.Fatalf("got version %d, found %v and %v, want the entry replaced with version 2", entry.SchemaVersion, found, err)
+	}
+}
+
+func TestUpdateExpiredEntry(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(memory.Config{})
+	clock := cachetest.NewFakeClock(time.Now())
+
+	// the namespace clock is ahead of the store, which still holds the entry the namespace sees as expired
+	ns := cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores: []cache.Store{store},
+		Fresh:  time.Minute,
+		Stale:  time.Hour,
+		Clock:  clock,
+	})
+
+	if err := ns.Set(ctx, "alice", user{Name: "old"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+
+	value, err := ns.Update(ctx, "alice", func(old *user) (*user, error) {
+		if old != nil {
+			t.Errorf("got %v, want an expired entry to be passed as nil", old)
+		}
+		return &user{Name: "new"}, nil
+	}, nil)
+	if err != nil || value == nil || value.Name != "new" {
+		t.Fatalf("got %v and %v, want the expired entry to be replaced", value, err)
+	}
+}
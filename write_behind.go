@@ -0,0 +1,341 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/steamsets/go-cache/pkg/telemetry"
+	"github.com/steamsets/go-cache/pkg/types"
+)
+
+type WriteBehindConfig struct {
+	// Maximum amount of keys waiting to be written to the lower tiers, writes for new keys
+	// are dropped once it is full. Defaults to 10_000
+	QueueSize int
+}
+
+type WriteBehindStats struct {
+	// Amount of keys currently waiting to be written
+	Depth int
+	// Writes that were dropped because the queue was full
+	Dropped uint64
+	// Values that were written to all lower tiers
+	Written uint64
+	// Values that failed to be written to at least one lower tier
+	Failed uint64
+}
+
+// writeBehind writes values to the lower tiers in the background, repeated writes to the same
+// key while it is still queued only write the latest value.
+type writeBehind struct {
+	ns        types.TNamespace
+	tiers     []tier
+	fresh     time.Duration
+	stale     time.Duration
+	queueSize int
+
+	mu       sync.Mutex
+	idle     *sync.Cond
+	pending  map[string]pendingValue
+	order    []string
+	flushing bool
+	// keys of the batch that is being written and the ones of them that were removed in the meantime
+	inFlight map[string]struct{}
+	removed  map[string]struct{}
+	closed   bool
+	wake     chan struct{}
+	// closed by Close to stop the writer, stopped is closed once the queue was drained and run returned
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	dropped atomic.Uint64
+	written atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func newWriteBehind(ns types.TNamespace, tiers []tier, fresh time.Duration, stale time.Duration, cfg WriteBehindConfig) *writeBehind {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10_000
+	}
+
+	w := &writeBehind{
+		ns:        ns,
+		tiers:     tiers,
+		fresh:     fresh,
+		stale:     stale,
+		queueSize: cfg.QueueSize,
+		pending:   make(map[string]pendingValue),
+		order:     make([]string, 0),
+		inFlight:  make(map[string]struct{}),
+		removed:   make(map[string]struct{}),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	w.idle = sync.NewCond(&w.mu)
+
+	return w
+}
+
+// enqueue queues the values for the lower tiers, once the writer was stopped they are written right away
+func (w *writeBehind) enqueue(ctx context.Context, values ...pendingValue) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		w.writeWithRetry(ctx, values, 1)
+		return
+	}
+
+	for _, v := range values {
+		key := v.value.Key
+		if _, ok := w.pending[key]; !ok {
+			if len(w.order) >= w.queueSize {
+				w.dropped.Add(1)
+				continue
+			}
+
+			w.order = append(w.order, key)
+		}
+
+		// it is written again after the batch, so it must not be removed once the batch is done
+		delete(w.removed, key)
+		w.pending[key] = v
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel drops queued writes, so a removed key does not come back once the queue is written.
+// Keys that are being written right now are removed again once their batch is done
+func (w *writeBehind) cancel(keys []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := w.inFlight[key]; ok {
+			w.removed[key] = struct{}{}
+		}
+
+		if _, ok := w.pending[key]; !ok {
+			continue
+		}
+
+		delete(w.pending, key)
+		for i, k := range w.order {
+			if k == key {
+				w.order = append(w.order[:i], w.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// run writes the queue until ctx is cancelled or close is called,
+// everything that is still queued by then is flushed before it returns
+func (w *writeBehind) run(ctx context.Context) {
+	defer close(w.stopped)
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.shutdown(ctx)
+			return
+		case <-w.stop:
+			w.shutdown(ctx)
+			return
+		case <-w.wake:
+			w.drain(ctx, 1)
+		}
+	}
+}
+
+func (w *writeBehind) shutdown(ctx context.Context) {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	// the queue has to be written even though ctx is done, failed batches are retried as this is the last chance
+	w.drain(context.WithoutCancel(ctx), closeAttempts)
+}
+
+// close stops the writer and blocks until the queue was drained or ctx is done
+func (w *writeBehind) close(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeAttempts is how often a batch is tried when the queue is drained on close
+const closeAttempts = 3
+
+func (w *writeBehind) drain(ctx context.Context, attempts int) {
+	for {
+		// whatever is left is written by the drain on close, which retries failed batches
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.mu.Lock()
+		if len(w.order) == 0 {
+			w.idle.Broadcast()
+			w.mu.Unlock()
+			return
+		}
+
+		values := make([]pendingValue, 0, len(w.order))
+		for _, key := range w.order {
+			values = append(values, w.pending[key])
+		}
+		for _, key := range w.order {
+			w.inFlight[key] = struct{}{}
+		}
+		w.pending = make(map[string]pendingValue)
+		w.order = make([]string, 0)
+		w.flushing = true
+		w.mu.Unlock()
+
+		w.writeWithRetry(ctx, values, attempts)
+
+		w.mu.Lock()
+		removed := make([]string, 0, len(w.removed))
+		for key := range w.removed {
+			removed = append(removed, key)
+		}
+		w.inFlight = make(map[string]struct{})
+		w.removed = make(map[string]struct{})
+		w.mu.Unlock()
+
+		// the remove may have reached the lower tiers before the batch did
+		if len(removed) > 0 {
+			w.remove(ctx, removed)
+		}
+
+		w.mu.Lock()
+		w.flushing = false
+		w.mu.Unlock()
+	}
+}
+
+func (w *writeBehind) writeWithRetry(ctx context.Context, values []pendingValue, attempts int) {
+	backoff := 50 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		err := w.write(ctx, values)
+		if err == nil {
+			w.written.Add(uint64(len(values)))
+			return
+		}
+
+		if attempt >= attempts {
+			w.failed.Add(uint64(len(values)))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			w.failed.Add(uint64(len(values)))
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (w *writeBehind) remove(ctx context.Context, keys []string) {
+	ctx, span := telemetry.NewSpan(ctx, "tiered.write-behind-remove")
+	defer span.End()
+
+	if err := fanOut(ctx, w.ns, "remove", WriteAll, writableTiers(w.tiers), keys, func(ctx context.Context, tier tier) error {
+		return tier.Store.Remove(ctx, w.ns, keys)
+	}); err != nil {
+		telemetry.RecordError(span, err)
+	}
+}
+
+func (w *writeBehind) write(ctx context.Context, values []pendingValue) error {
+	ctx, span := telemetry.NewSpan(ctx, "tiered.write-behind")
+	defer span.End()
+	telemetry.WithAttributes(span,
+		telemetry.AttributeKV{Key: "keys_amount", Value: len(values)},
+		telemetry.AttributeKV{Key: "namespace", Value: string(w.ns)},
+	)
+
+	keys := make([]string, 0, len(values))
+	for _, v := range values {
+		keys = append(keys, v.value.Key)
+	}
+
+	if err := fanOut(ctx, w.ns, "set-many", WriteAll, writableTiers(w.tiers), keys, func(ctx context.Context, tier tier) error {
+		values := tier.values(values, w.fresh, w.stale)
+		if len(values) == 0 {
+			return nil
+		}
+
+		return tier.Store.SetMany(ctx, w.ns, values, nil)
+	}); err != nil {
+		telemetry.RecordError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// flush blocks until the queue is empty or ctx is done
+func (w *writeBehind) flush(ctx context.Context) error {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+
+	// wakes the waiting goroutine up once ctx is done, so it does not wait for the queue forever
+	stop := context.AfterFunc(ctx, func() {
+		w.mu.Lock()
+		w.idle.Broadcast()
+		w.mu.Unlock()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		w.mu.Lock()
+		for (len(w.order) > 0 || w.flushing) && ctx.Err() == nil {
+			w.idle.Wait()
+		}
+		w.mu.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *writeBehind) stats() WriteBehindStats {
+	w.mu.Lock()
+	depth := len(w.order)
+	w.mu.Unlock()
+
+	return WriteBehindStats{
+		Depth:   depth,
+		Dropped: w.dropped.Load(),
+		Written: w.written.Load(),
+		Failed:  w.failed.Load(),
+	}
+}
@@ -0,0 +1,189 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/steamsets/go-cache"
+	"github.com/steamsets/go-cache/pkg/types"
+	"github.com/steamsets/go-cache/store/memory"
+)
+
+// slowStore blocks the first SetMany until release is closed and fails the next ones as often as failures says
+type slowStore struct {
+	*memory.MemoryStore
+	started  chan struct{}
+	release  chan struct{}
+	blocked  atomic.Bool
+	failures atomic.Int32
+}
+
+func newSlowStore() *slowStore {
+	return &slowStore{
+		MemoryStore: memory.New(memory.Config{}),
+		started:     make(chan struct{}, 1),
+		release:     make(chan struct{}),
+	}
+}
+
+func (s *slowStore) SetMany(ctx context.Context, ns types.TNamespace, values []types.TValue, opts *types.SetOptions) error {
+	if s.blocked.CompareAndSwap(false, true) {
+		s.started <- struct{}{}
+		<-s.release
+	}
+
+	if s.failures.Add(-1) >= 0 {
+		return errors.New("store unavailable")
+	}
+
+	return s.MemoryStore.SetMany(ctx, ns, values, opts)
+}
+
+func newWriteBehindNamespace(ctx context.Context, lower cache.Store) cache.Namespace[user] {
+	return cache.NewNamespace[user]("user", ctx, cache.NamespaceConfig{
+		Stores:      []cache.Store{memory.New(memory.Config{}), lower},
+		Fresh:       time.Minute,
+		Stale:       time.Hour,
+		WriteBehind: &cache.WriteBehindConfig{},
+	})
+}
+
+func TestWriteBehindRemoveWhileWriting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lower := newSlowStore()
+	ns := newWriteBehindNamespace(ctx, lower)
+
+	if err := ns.Set(ctx, "alice", user{Name: "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch holding alice is being written while it is removed
+	<-lower.started
+	if err := ns.Remove(ctx, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	close(lower.release)
+
+	if err := ns.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := lower.Get(ctx, "user", "alice", &user{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found {
+		t.Fatal("removed key came back once its batch was written")
+	}
+}
+
+func TestWriteBehindRetryOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	lower := newSlowStore()
+	ns := newWriteBehindNamespace(ctx, lower)
+
+	if err := ns.Set(ctx, "bob", user{Name: "bob"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice is still queued when the namespace is closed, bob's batch and the first try on close fail
+	<-lower.started
+	if err := ns.Set(ctx, "alice", user{Name: "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	lower.failures.Store(2)
+	cancel()
+	close(lower.release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for ns.WriteBehindStats().Written == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue was not written on close, stats %+v", ns.WriteBehindStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, found, err := lower.Get(context.Background(), "user", "alice", &user{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found {
+		t.Fatal("failed batch was not retried on close")
+	}
+}
+
+func TestWriteBehindFlushCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lower := newSlowStore()
+	defer close(lower.release)
+	ns := newWriteBehindNamespace(ctx, lower)
+
+	if err := ns.Set(ctx, "alice", user{Name: "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	flushCtx, flushCancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer flushCancel()
+
+	if err := ns.Flush(flushCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Flush got %v while the batch was stuck, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWriteBehindClose(t *testing.T) {
+	ctx := context.Background()
+	lower := newSlowStore()
+	ns := newWriteBehindNamespace(ctx, lower)
+
+	if err := ns.Set(ctx, "alice", user{Name: "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob is still queued while the batch holding alice is stuck
+	<-lower.started
+	if err := ns.Set(ctx, "bob", user{Name: "bob"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer closeCancel()
+
+	if err := ns.Close(closeCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close got %v while the batch was stuck, want context.DeadlineExceeded", err)
+	}
+
+	close(lower.release)
+	if err := ns.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		_, found, err := lower.Get(ctx, "user", name, &user{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !found {
+			t.Fatalf("%s was not written to the lower tier when Close returned", name)
+		}
+	}
+
+	// the queue is stopped, later writes reach the lower tier before Set returns
+	if err := ns.Set(ctx, "carol", user{Name: "carol"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, _ := lower.Get(ctx, "user", "carol", &user{}); !found {
+		t.Fatal("write after Close was not written to the lower tier")
+	}
+}